@@ -88,11 +88,19 @@ func (t *Transport) roundTrip(req *http.Request) (resp *http.Response, err error
 
 	if resp != nil && req.Method == http.MethodHead && err == nil && t.ProgressBar != nil {
 		if resp.ContentLength > 0 {
-			contentLength := int(resp.ContentLength)
-			b := make([]byte, contentLength)
-			_, _ = t.ProgressBar.Write(b)
+			// stream the reported size rather than allocate it, blobs can be many gigabytes
+			_, _ = io.CopyN(t.ProgressBar, zeroReader{}, resp.ContentLength)
 		}
 	}
 
 	return resp, err
 }
+
+// zeroReader reads an endless stream of zero bytes.
+type zeroReader struct{}
+
+// Read fills p with zero bytes.
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
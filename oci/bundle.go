@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+	orasRegistry "oras.land/oras-go/v2/registry"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+// BundleManifestFile is the name of the file at the root of a bundle archive describing its contents
+const BundleManifestFile = "bundle.json"
+
+// BundleReference is an artifact to include in a bundle.
+type BundleReference struct {
+	// URL is the reference to the artifact, with or without the oci:// prefix
+	URL string
+	// Platforms limits an image index to the manifests matching these platforms, all platforms are bundled if empty
+	Platforms []ocispec.Platform
+}
+
+// BundleArtifact is an artifact contained in a bundle.
+type BundleArtifact struct {
+	Reference string             `json:"reference"`
+	Root      ocispec.Descriptor `json:"root"`
+}
+
+// BundleManifest describes the contents of a bundle archive.
+type BundleManifest struct {
+	Artifacts []BundleArtifact `json:"artifacts"`
+	// Checksums maps each file in the archive's OCI image layout to its sha256 checksum
+	Checksums map[string]string `json:"checksums"`
+}
+
+// CreateBundle writes the given artifacts into a single reproducible OCI image layout tarball at archivePath.
+//
+// Blobs shared between artifacts are only stored once. A BundleManifestFile listing the bundled artifacts
+// and the checksum of every file in the layout is written alongside the layout. The modifiers are applied to
+// every remote the artifacts are read from.
+func CreateBundle(ctx context.Context, refs []BundleReference, archivePath string, mods ...Modifier) (*BundleManifest, error) {
+	tmp, err := os.MkdirTemp("", "oci-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	store, err := oci.NewWithContext(ctx, tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the index is written once all artifacts are added so its order is stable
+	store.AutoSaveIndex = false
+
+	bundle := &BundleManifest{}
+	for _, ref := range refs {
+		remote, err := NewOrasRemote(ref.URL, ocispec.Platform{}, mods...)
+		if err != nil {
+			return nil, err
+		}
+		root, err := remote.bundleArtifact(ctx, store, ref.Platforms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bundle %s: %w", ref.URL, err)
+		}
+		bundle.Artifacts = append(bundle.Artifacts, BundleArtifact{
+			Reference: remote.repo.Reference.String(),
+			Root:      root,
+		})
+	}
+	slices.SortFunc(bundle.Artifacts, func(a, b BundleArtifact) int {
+		return strings.Compare(a.Reference, b.Reference)
+	})
+
+	if err := writeBundleIndex(tmp, bundle.Artifacts); err != nil {
+		return nil, err
+	}
+	// the store leaves behind an empty directory for in-progress blobs
+	_ = os.Remove(filepath.Join(tmp, "ingest"))
+
+	bundle.Checksums, err = checksumDir(tmp)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, BundleManifestFile), b, helpers.ReadWriteUser); err != nil {
+		return nil, err
+	}
+
+	if err := helpers.CreateReproducibleTarballFromDir(tmp, "", archivePath); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// Unbundle pushes every artifact in the bundle archive at archivePath to registry, keeping their repository and tag.
+//
+// The archive is verified against its BundleManifestFile before anything is pushed. registry is a host, optionally
+// followed by a path that is prepended to each repository. The modifiers are applied to every destination remote.
+func Unbundle(ctx context.Context, archivePath string, registry string, progressBar helpers.ProgressWriter, mods ...Modifier) (*BundleManifest, error) {
+	if progressBar == nil {
+		progressBar = helpers.DiscardProgressWriter{}
+	}
+	// blobs are pushed concurrently, so all progress updates must be serialized
+	progressBar = &syncProgressWriter{ProgressWriter: progressBar}
+	bundle, err := ReadBundleManifest(archivePath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := oci.NewFromTar(ctx, archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	registry = strings.TrimSuffix(strings.TrimPrefix(registry, helpers.OCIURLPrefix), "/")
+	// the existence checks of the copy decide what is pushed, so they are never served by mirrors
+	ctx = withoutMirrors(ctx)
+	for idx, artifact := range bundle.Artifacts {
+		ref, err := orasRegistry.ParseReference(artifact.Reference)
+		if err != nil {
+			return nil, err
+		}
+		url := fmt.Sprintf("%s/%s", registry, ref.Repository)
+		if ref.ValidateReferenceAsDigest() == nil {
+			url += "@" + ref.Reference
+		} else {
+			url += ":" + ref.Reference
+		}
+
+		dst, err := NewOrasRemote(url, ocispec.Platform{}, mods...)
+		if err != nil {
+			return nil, err
+		}
+		dst.SetProgressWriter(progressBar)
+		copyOpts := dst.GetDefaultCopyOpts()
+		copyOpts.Concurrency = dst.concurrency
+		err = oras.CopyGraph(ctx, store, dst.repo, artifact.Root, copyOpts.CopyGraphOptions)
+		if err == nil && ref.ValidateReferenceAsDigest() != nil {
+			err = dst.repo.Tag(ctx, artifact.Root, ref.Reference)
+		}
+		dst.ClearProgressWriter()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unbundle %s to %s: %w", artifact.Reference, dst.repo.Reference, err)
+		}
+		progressBar.Updatef("[%d/%d] artifacts unbundled", idx+1, len(bundle.Artifacts))
+	}
+	return bundle, nil
+}
+
+// ReadBundleManifest reads the BundleManifestFile from the bundle archive at archivePath.
+//
+// If verify is true every file in the archive is checked against the checksums in the manifest.
+func ReadBundleManifest(archivePath string, verify bool) (*BundleManifest, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var bundle *BundleManifest
+	checksums := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Name == BundleManifestFile {
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(b, &bundle); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", BundleManifestFile, err)
+			}
+			continue
+		}
+		if verify {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, err
+			}
+			checksums[header.Name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	if bundle == nil {
+		return nil, fmt.Errorf("%s is not a bundle: %s not found", archivePath, BundleManifestFile)
+	}
+	if !verify {
+		return bundle, nil
+	}
+
+	for name, expected := range bundle.Checksums {
+		actual, ok := checksums[name]
+		if !ok {
+			return nil, fmt.Errorf("bundle %s is missing %s", archivePath, name)
+		}
+		if actual != expected {
+			return nil, fmt.Errorf("expected sha256 of %s in bundle %s to be %s, found %s", name, archivePath, expected, actual)
+		}
+	}
+	for name := range checksums {
+		if _, ok := bundle.Checksums[name]; !ok {
+			return nil, fmt.Errorf("bundle %s contains unexpected file %s", archivePath, name)
+		}
+	}
+	return bundle, nil
+}
+
+// bundleArtifact copies the artifact into the store, limiting image indexes to the given platforms.
+func (o *OrasRemote) bundleArtifact(ctx context.Context, store *oci.Store, platforms []ocispec.Platform) (ocispec.Descriptor, error) {
+	root, err := o.repo.Resolve(ctx, o.repo.Reference.Reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	copyOpts := o.GetDefaultCopyOpts()
+	copyOpts.Concurrency = o.concurrency
+
+	if len(platforms) == 0 || root.MediaType != ocispec.MediaTypeImageIndex {
+		if err := oras.CopyGraph(ctx, o.src(), store, root, copyOpts.CopyGraphOptions); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		return root, nil
+	}
+
+	index, err := FetchUnmarshal[ocispec.Index](ctx, o, json.Unmarshal, root)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	index.Manifests = helpers.Filter(index.Manifests, func(desc ocispec.Descriptor) bool {
+		return slices.ContainsFunc(platforms, func(platform ocispec.Platform) bool {
+			return platformMatches(platform, desc.Platform)
+		})
+	})
+	if len(index.Manifests) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("no manifests in %q match the requested platforms", o.repo.Reference.Reference)
+	}
+	for _, desc := range index.Manifests {
+		if err := oras.CopyGraph(ctx, o.src(), store, desc, copyOpts.CopyGraphOptions); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	b, err := json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	filtered := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, b)
+	exists, err := store.Exists(ctx, filtered)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if !exists {
+		if err := store.Push(ctx, filtered, bytes.NewReader(b)); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	return filtered, nil
+}
+
+// writeBundleIndex writes the layout's index.json listing only the bundled artifacts, in order.
+func writeBundleIndex(dir string, artifacts []BundleArtifact) error {
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		Manifests: []ocispec.Descriptor{},
+	}
+	for _, artifact := range artifacts {
+		desc := ocispec.Descriptor{
+			MediaType:   artifact.Root.MediaType,
+			Digest:      artifact.Root.Digest,
+			Size:        artifact.Root.Size,
+			Annotations: map[string]string{ocispec.AnnotationRefName: artifact.Reference},
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ocispec.ImageIndexFile), b, helpers.ReadWriteUser)
+}
+
+// checksumDir returns the sha256 checksum of every regular file in dir, keyed by its slash-separated relative path.
+func checksumDir(dir string) (map[string]string, error) {
+	checksums := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, err := helpers.GetSHA256OfFile(path)
+		if err != nil {
+			return err
+		}
+		checksums[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/file"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func (suite *OCISuite) TestBundle() {
+	ctx := context.TODO()
+	srcRegistryURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	suite.publishLayoutPackage(ctx, srcRegistryURL, "amd64", "arm64")
+
+	// a second package that shares a layer with the first
+	otherURL := strings.Replace(srcRegistryURL, "/package:", "/other:", 1)
+	srcTempDir := suite.T().TempDir()
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	path := filepath.Join(srcTempDir, "test-file")
+	suite.NoError(os.WriteFile(path, []byte("amd64-file"), helpers.ReadWriteUser))
+	desc, err := src.Add(ctx, "test-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	other, err := NewOrasRemote(otherURL, PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.publishPackageTo(other, src, []ocispec.Descriptor{desc})
+
+	refs := []BundleReference{
+		{URL: otherURL},
+		{URL: srcRegistryURL, Platforms: []ocispec.Platform{PlatformForArch("amd64")}},
+	}
+	archivePath := filepath.Join(suite.T().TempDir(), "bundle.tar")
+	bundle, err := CreateBundle(ctx, refs, archivePath, WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.Len(bundle.Artifacts, 2)
+	suite.Contains(bundle.Checksums, ocispec.ImageIndexFile)
+	suite.Contains(bundle.Checksums, "blobs/sha256/"+desc.Digest.Encoded())
+
+	// bundles of the same artifacts are byte for byte identical
+	secondPath := filepath.Join(suite.T().TempDir(), "bundle.tar")
+	_, err = CreateBundle(ctx, refs, secondPath, WithPlainHTTP(true))
+	suite.NoError(err)
+	first, err := helpers.GetSHA256OfFile(archivePath)
+	suite.NoError(err)
+	second, err := helpers.GetSHA256OfFile(secondPath)
+	suite.NoError(err)
+	suite.Equal(first, second)
+
+	dstRegistryURL := suite.setupInMemoryRegistry(ctx)
+	dstRegistry := strings.TrimSuffix(strings.TrimPrefix(dstRegistryURL, helpers.OCIURLPrefix), "/package:1.0.1")
+	testWriter := &TestProgressWriter{}
+	_, err = Unbundle(ctx, archivePath, dstRegistry+"/mirror", testWriter, WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.Positive(testWriter.bytesSent)
+
+	for _, repo := range []string{"package", "other"} {
+		remote, err := NewOrasRemote(dstRegistry+"/mirror/"+repo+":0.0.1", PlatformForArch("amd64"), WithPlainHTTP(true))
+		suite.NoError(err)
+		root, err := remote.FetchRoot(ctx)
+		suite.NoError(err)
+		b, err := remote.FetchLayer(ctx, root.Locate("test-file"))
+		suite.NoError(err)
+		suite.Equal("amd64-file", string(b))
+	}
+
+	// blobs are pushed to the registry even when a mirror of it already holds them
+	mirroredRegistryURL := suite.setupInMemoryRegistry(ctx)
+	mirroredRegistry := strings.TrimSuffix(strings.TrimPrefix(mirroredRegistryURL, helpers.OCIURLPrefix), "/package:1.0.1")
+	_, err = Unbundle(ctx, archivePath, mirroredRegistry, nil, WithPlainHTTP(true),
+		WithMirrors(mirroredRegistry, Mirror{Endpoint: dstRegistry + "/mirror", PlainHTTP: true}))
+	suite.NoError(err)
+	for _, repo := range []string{"package", "other"} {
+		remote, err := NewOrasRemote(mirroredRegistry+"/"+repo+":0.0.1", PlatformForArch("amd64"), WithPlainHTTP(true))
+		suite.NoError(err)
+		root, err := remote.FetchRoot(ctx)
+		suite.NoError(err)
+		b, err := remote.FetchLayer(ctx, root.Locate("test-file"))
+		suite.NoError(err)
+		suite.Equal("amd64-file", string(b))
+	}
+
+	// only the requested platforms are bundled
+	arm, err := NewOrasRemote(dstRegistry+"/mirror/package:0.0.1", PlatformForArch("arm64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	_, err = arm.FetchRoot(ctx)
+	suite.Error(err)
+}
+
+func (suite *OCISuite) TestBundleVerify() {
+	ctx := context.TODO()
+	srcRegistryURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	suite.publishLayoutPackage(ctx, srcRegistryURL, "amd64")
+
+	dir := suite.T().TempDir()
+	archivePath := filepath.Join(dir, "bundle.tar")
+	bundle, err := CreateBundle(ctx, []BundleReference{{URL: srcRegistryURL}}, archivePath, WithPlainHTTP(true))
+	suite.NoError(err)
+
+	read, err := ReadBundleManifest(archivePath, true)
+	suite.NoError(err)
+	suite.Equal(bundle, read)
+
+	// tampering with the layout is caught before anything is pushed
+	layoutDir := filepath.Join(dir, "layout")
+	for name := range bundle.Checksums {
+		suite.NoError(helpers.CreatePathAndCopy(archivePath, filepath.Join(layoutDir, name)))
+	}
+	b, err := json.Marshal(bundle)
+	suite.NoError(err)
+	suite.NoError(os.WriteFile(filepath.Join(layoutDir, BundleManifestFile), b, helpers.ReadWriteUser))
+	tamperedPath := filepath.Join(dir, "tampered.tar")
+	suite.NoError(helpers.CreateReproducibleTarballFromDir(layoutDir, "", tamperedPath))
+	_, err = ReadBundleManifest(tamperedPath, true)
+	suite.ErrorContains(err, "expected sha256")
+	_, err = Unbundle(ctx, tamperedPath, "localhost:1", nil)
+	suite.ErrorContains(err, "expected sha256")
+}
@@ -23,12 +23,19 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
 	"oras.land/oras-go/v2/registry"
 )
 
@@ -41,14 +48,51 @@ func (fn closer) Close() error {
 // Cache target struct.
 type target struct {
 	oras.ReadOnlyTarget
-	cache content.Storage
+	cache      content.Storage
+	onAccess   func(ocispec.Descriptor) error
+	quarantine func(context.Context, ocispec.Descriptor) error
+	verifyRate float64
+}
+
+// Option is a function that modifies a caching target
+type Option func(*target)
+
+// WithVerify verifies the digest of every blob read from the cache before it is returned.
+//
+// Corrupt blobs are removed from the cache and fetched from the source again. See New for where they go.
+func WithVerify() Option {
+	return WithVerifySampleRate(1)
+}
+
+// WithVerifySampleRate verifies the digest of a random fraction, between 0 and 1, of the blobs read from the cache.
+//
+// Corrupt blobs are removed from the cache and fetched from the source again. See New for where they go.
+func WithVerifySampleRate(rate float64) Option {
+	return func(t *target) {
+		t.verifyRate = rate
+	}
 }
 
 // New generates a new target storage with caching.
-func New(source oras.ReadOnlyTarget, cache content.Storage) oras.ReadOnlyTarget {
+//
+// Corrupt blobs found by WithVerify or WithVerifySampleRate are deleted, so cache must be a content.Deleter.
+// Use Manager.New instead to keep them in the quarantine directory of a managed cache.
+func New(source oras.ReadOnlyTarget, cache content.Storage, opts ...Option) oras.ReadOnlyTarget {
+	return newTarget(source, cache, nil, nil, opts...)
+}
+
+// newTarget generates a new target storage with caching, calling onAccess whenever cached content is read
+// and quarantine when cached content is corrupt.
+func newTarget(source oras.ReadOnlyTarget, cache content.Storage, onAccess func(ocispec.Descriptor) error,
+	quarantine func(context.Context, ocispec.Descriptor) error, opts ...Option) oras.ReadOnlyTarget {
 	t := &target{
 		ReadOnlyTarget: source,
 		cache:          cache,
+		onAccess:       onAccess,
+		quarantine:     quarantine,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
 	if refFetcher, ok := source.(registry.ReferenceFetcher); ok {
 		return &referenceTarget{
@@ -60,30 +104,70 @@ func New(source oras.ReadOnlyTarget, cache content.Storage) oras.ReadOnlyTarget
 }
 
 // Fetch fetches the content identified by the descriptor.
+//
+// Concurrent fetches of content missing from the cache share a single fetch from the origin: the first caller
+// streams the content while it is cached, the others wait for it to be cached and read it from the cache. Waiting
+// callers stop waiting and fetch from the origin themselves when the shared fetch is not being read, so fetching
+// content again before closing the first reader does not deadlock.
 func (t *target) Fetch(ctx context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
-	rc, err := t.cache.Fetch(ctx, target)
+	rc, err := t.fetchCached(ctx, target)
 	if err == nil {
 		// Fetch from cache
 		return rc, nil
 	}
 
+	f, leader := joinFlight(t.cache, target.Digest)
+	if !leader {
+		err := f.wait(ctx)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err == nil {
+			if rc, err := t.fetchCached(ctx, target); err == nil {
+				return rc, nil
+			}
+		}
+		// the shared fetch failed or stalled, fetch from origin without waiting on others
+		if rc, err = t.ReadOnlyTarget.Fetch(ctx, target); err != nil {
+			return nil, err
+		}
+		return t.cacheReadCloser(ctx, rc, target, nil), nil
+	}
+
+	// another fetch may have cached the content since it was checked
+	if rc, err := t.fetchCached(ctx, target); err == nil {
+		leaveFlight(t.cache, target.Digest, f, nil)
+		return rc, nil
+	}
 	if rc, err = t.ReadOnlyTarget.Fetch(ctx, target); err != nil {
+		leaveFlight(t.cache, target.Digest, f, err)
 		return nil, err
 	}
 
 	// Fetch from origin with caching
-	return t.cacheReadCloser(ctx, rc, target), nil
+	f.streaming.Store(true)
+	return t.cacheReadCloser(ctx, &flightReader{ReadCloser: rc, flight: f}, target, func(err error) {
+		leaveFlight(t.cache, target.Digest, f, err)
+	}), nil
 }
 
-func (t *target) cacheReadCloser(ctx context.Context, rc io.ReadCloser, target ocispec.Descriptor) io.ReadCloser {
+// cacheReadCloser tees rc into the cache, calling done with the outcome once the returned reader is closed.
+func (t *target) cacheReadCloser(ctx context.Context, rc io.ReadCloser, target ocispec.Descriptor, done func(error)) io.ReadCloser {
 	pr, pw := io.Pipe()
 	var wg sync.WaitGroup
+	var once sync.Once
 
 	wg.Add(1)
 	var pushErr error
 	go func() {
 		defer wg.Done()
 		pushErr = t.cache.Push(ctx, target, pr)
+		if errors.Is(pushErr, errdef.ErrAlreadyExists) {
+			// cached by someone else in the meantime, keep the reader flowing
+			pushErr = nil
+			_, _ = io.Copy(io.Discard, pr)
+			return
+		}
 		if pushErr != nil {
 			pr.CloseWithError(pushErr)
 		}
@@ -96,18 +180,170 @@ func (t *target) cacheReadCloser(ctx context.Context, rc io.ReadCloser, target o
 		Reader: io.TeeReader(rc, pw),
 		Closer: closer(func() error {
 			rcErr := rc.Close()
-			if err := pw.Close(); err != nil {
-				return err
+			err := pw.Close()
+			if err == nil {
+				wg.Wait()
+				err = pushErr
+			}
+			if done != nil {
+				once.Do(func() { done(err) })
 			}
-			wg.Wait()
-			if pushErr != nil {
-				return pushErr
+			if err != nil {
+				return err
 			}
 			return rcErr
 		}),
 	}
 }
 
+// flightStallInterval is how long a shared fetch may go unread before waiting callers fetch from the origin themselves
+const flightStallInterval = time.Second
+
+// flight is a fetch from origin into a cache shared by concurrent callers.
+type flight struct {
+	done chan struct{}
+	err  error
+	// streaming is set once the origin is being read, reads and reading track the reads of the caller that started it
+	streaming atomic.Bool
+	reads     atomic.Int64
+	reading   atomic.Int32
+}
+
+var (
+	flightsMu sync.Mutex
+	// flights holds the fetches in flight into each cache, by digest
+	flights = map[content.Storage]map[digest.Digest]*flight{}
+)
+
+// joinFlight returns the in-flight fetch of dgst into cache, and true if the caller started it and must call leaveFlight.
+func joinFlight(cache content.Storage, dgst digest.Digest) (*flight, bool) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	if f, ok := flights[cache][dgst]; ok {
+		return f, false
+	}
+	if flights[cache] == nil {
+		flights[cache] = map[digest.Digest]*flight{}
+	}
+	f := &flight{done: make(chan struct{})}
+	flights[cache][dgst] = f
+	return f, true
+}
+
+// leaveFlight finishes the in-flight fetch of dgst into cache, releasing the callers waiting on it.
+func leaveFlight(cache content.Storage, dgst digest.Digest, f *flight, err error) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	delete(flights[cache], dgst)
+	if len(flights[cache]) == 0 {
+		delete(flights, cache)
+	}
+	f.err = err
+	close(f.done)
+}
+
+// errFlightStalled is returned by wait when the shared fetch is not being read
+var errFlightStalled = errors.New("shared fetch is not being read")
+
+// wait waits for the flight to finish and returns its error.
+//
+// It returns early with the error of ctx, or with errFlightStalled when the caller that started the fetch has not
+// read from it for flightStallInterval, such as when it is itself waiting on a fetch of the same content.
+func (f *flight) wait(ctx context.Context) error {
+	ticker := time.NewTicker(flightStallInterval)
+	defer ticker.Stop()
+	last := f.reads.Load()
+	for {
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			reads := f.reads.Load()
+			if f.streaming.Load() && f.reading.Load() == 0 && reads == last {
+				return errFlightStalled
+			}
+			last = reads
+		}
+	}
+}
+
+// flightReader tracks the reads of a shared fetch from the origin.
+type flightReader struct {
+	io.ReadCloser
+	flight *flight
+}
+
+// Read reads from the origin, marking the shared fetch as being read.
+func (r *flightReader) Read(p []byte) (int, error) {
+	r.flight.reading.Add(1)
+	defer r.flight.reading.Add(-1)
+	r.flight.reads.Add(1)
+	return r.ReadCloser.Read(p)
+}
+
+// fetchCached fetches the content from the cache, verifying it first if it is sampled for verification.
+//
+// Corrupt content is removed from the cache and an error is returned so the caller falls back to the source.
+func (t *target) fetchCached(ctx context.Context, target ocispec.Descriptor) (io.ReadCloser, error) {
+	rc, err := t.cache.Fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if t.verifyRate <= 0 || (t.verifyRate < 1 && rand.Float64() >= t.verifyRate) {
+		t.access(target)
+		return rc, nil
+	}
+
+	vr := content.NewVerifyReader(rc, target)
+	_, err = io.Copy(io.Discard, vr)
+	if err == nil {
+		err = vr.Verify()
+	}
+	if err != nil {
+		rc.Close()
+		if qErr := t.remove(ctx, target); qErr != nil {
+			return nil, fmt.Errorf("failed to remove corrupt blob %s from the cache: %w", target.Digest, errors.Join(err, qErr))
+		}
+		return nil, fmt.Errorf("removed corrupt blob %s from the cache: %w", target.Digest, err)
+	}
+
+	// rewind the verified content rather than reading it from the cache again
+	if seeker, ok := rc.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err == nil {
+			t.access(target)
+			return rc, nil
+		}
+	}
+	rc.Close()
+	rc, err = t.cache.Fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	t.access(target)
+	return rc, nil
+}
+
+// remove removes corrupt content from the cache.
+func (t *target) remove(ctx context.Context, target ocispec.Descriptor) error {
+	if t.quarantine != nil {
+		return t.quarantine(ctx, target)
+	}
+	deleter, ok := t.cache.(content.Deleter)
+	if !ok {
+		return errors.New("cache does not support deleting content")
+	}
+	return deleter.Delete(ctx, target)
+}
+
+// access records a read of cached content, failing to record it never fails the read.
+func (t *target) access(target ocispec.Descriptor) {
+	if t.onAccess != nil {
+		_ = t.onAccess(target)
+	}
+}
+
 // Exists returns true if the described content exists.
 func (t *target) Exists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
 	exists, err := t.cache.Exists(ctx, desc)
@@ -139,21 +375,20 @@ func (t *referenceTarget) FetchReference(ctx context.Context, reference string)
 		return ocispec.Descriptor{}, nil, err
 	}
 	if exists {
-		err = rc.Close()
-		if err != nil {
-			return ocispec.Descriptor{}, nil, err
-		}
+		// get rc from the cache, falling back to the origin if the cached content is corrupt
+		cached, err := t.fetchCached(ctx, target)
+		if err == nil {
+			err = rc.Close()
+			if err != nil {
+				cached.Close()
+				return ocispec.Descriptor{}, nil, err
+			}
 
-		// get rc from the cache
-		rc, err = t.cache.Fetch(ctx, target)
-		if err != nil {
-			return ocispec.Descriptor{}, nil, err
+			// no need to do tee'd push
+			return target, cached, nil
 		}
-
-		// no need to do tee'd push
-		return target, rc, nil
 	}
 
 	// Fetch from origin with caching
-	return target, t.cacheReadCloser(ctx, rc, target), nil
+	return target, t.cacheReadCloser(ctx, rc, target, nil), nil
 }
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// slowSource counts fetches and holds every read until release is closed.
+type slowSource struct {
+	*memory.Store
+	fetches atomic.Int32
+	release chan struct{}
+	fail    bool
+}
+
+func (s *slowSource) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	s.fetches.Add(1)
+	if s.fail {
+		return nil, errors.New("origin unavailable")
+	}
+	rc, err := s.Store.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	<-s.release
+	return rc, nil
+}
+
+func TestFetchDeduplicatesConcurrentFetches(t *testing.T) {
+	ctx := context.Background()
+	b := bytes.Repeat([]byte("shared layer "), 1024)
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	source := &slowSource{Store: memory.New(), release: make(chan struct{})}
+	require.NoError(t, source.Store.Push(ctx, desc, bytes.NewReader(b)))
+	store, err := oci.New(t.TempDir())
+	require.NoError(t, err)
+
+	var g errgroup.Group
+	for range 8 {
+		g.Go(func() error {
+			rc, err := New(source, store).Fetch(ctx, desc)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			got, err := content.ReadAll(rc, desc)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(b, got) {
+				return errors.New("unexpected content")
+			}
+			return nil
+		})
+	}
+	require.Eventually(t, func() bool { return source.fetches.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(source.release)
+	require.NoError(t, g.Wait())
+	require.Equal(t, int32(1), source.fetches.Load())
+
+	exists, err := store.Exists(ctx, desc)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestFetchFailedFlight(t *testing.T) {
+	ctx := context.Background()
+	b := []byte("unavailable")
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	source := &slowSource{Store: memory.New(), fail: true}
+	store, err := oci.New(t.TempDir())
+	require.NoError(t, err)
+
+	// a failed fetch is not shared with later callers
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := New(source, store).Fetch(ctx, desc)
+			require.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Positive(t, source.fetches.Load())
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	require.Empty(t, flights)
+}
+
+func TestFetchReentrant(t *testing.T) {
+	ctx := context.Background()
+	b := []byte("fetched twice")
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	source := memory.New()
+	require.NoError(t, source.Push(ctx, desc, bytes.NewReader(b)))
+	store, err := oci.New(t.TempDir())
+	require.NoError(t, err)
+
+	// fetching the same content again before reading the first fetch does not wait on it forever
+	first, err := New(source, store).Fetch(ctx, desc)
+	require.NoError(t, err)
+	second, err := New(source, store).Fetch(ctx, desc)
+	require.NoError(t, err)
+	for _, rc := range []io.ReadCloser{second, first} {
+		got, err := content.ReadAll(rc, desc)
+		require.NoError(t, err)
+		require.Equal(t, b, got)
+		require.NoError(t, rc.Close())
+	}
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	require.Empty(t, flights)
+}
+
+func TestFetchFlightsPerCache(t *testing.T) {
+	ctx := context.Background()
+	b := []byte("cached twice")
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	source := &slowSource{Store: memory.New(), release: make(chan struct{})}
+	require.NoError(t, source.Store.Push(ctx, desc, bytes.NewReader(b)))
+
+	// fetches into different caches do not wait on each other
+	var g errgroup.Group
+	stores := []*oci.Store{}
+	for range 2 {
+		store, err := oci.New(t.TempDir())
+		require.NoError(t, err)
+		stores = append(stores, store)
+		g.Go(func() error {
+			rc, err := New(source, store).Fetch(ctx, desc)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			_, err = content.ReadAll(rc, desc)
+			return err
+		})
+	}
+	require.Eventually(t, func() bool { return source.fetches.Load() == 2 }, time.Second, time.Millisecond)
+	close(source.release)
+	require.NoError(t, g.Wait())
+	for _, store := range stores {
+		exists, err := store.Exists(ctx, desc)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+}
@@ -0,0 +1,454 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const (
+	// lockFile is created in the cache directory while it is being pruned
+	lockFile = "prune.lock"
+	// staleLockAge is how old a lock file must be before it is assumed to be left behind by a crashed process
+	staleLockAge = 10 * time.Minute
+	// lockHeartbeatInterval is how often a held lock file is touched, well within staleLockAge
+	lockHeartbeatInterval = staleLockAge / 10
+	// staleIngestAge is how long a partially written blob must be untouched before it is pruned
+	staleIngestAge = time.Hour
+	// lockRetryInterval is how often a held lock is retried
+	lockRetryInterval = 100 * time.Millisecond
+	// QuarantineDir is the directory in the cache that corrupt blobs are moved to
+	QuarantineDir = "quarantine"
+)
+
+// Manager is a cache of blobs in an OCI image layout directory, bounded by size and age.
+//
+// The last access time of a blob is its modification time, so accesses from every process sharing the
+// directory are tracked without any shared metadata. Blobs are only removed by Prune.
+type Manager struct {
+	dir     string
+	store   *oci.Store
+	maxSize int64
+	maxAge  time.Duration
+}
+
+// ManagerOption is a function that modifies a Manager
+type ManagerOption func(*Manager)
+
+// WithMaxSize evicts the least recently used blobs on Prune until the cache is at most size bytes.
+func WithMaxSize(size int64) ManagerOption {
+	return func(m *Manager) {
+		m.maxSize = size
+	}
+}
+
+// WithMaxAge evicts blobs on Prune that have not been used for longer than age.
+func WithMaxAge(age time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.maxAge = age
+	}
+}
+
+// PruneResult is the outcome of a Prune.
+type PruneResult struct {
+	// Removed is the number of blobs removed
+	Removed int
+	// Freed is the number of bytes freed
+	Freed int64
+}
+
+// NewManager opens or creates the cache in dir.
+func NewManager(ctx context.Context, dir string, opts ...ManagerOption) (*Manager, error) {
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		dir:   dir,
+		store: store,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m, nil
+}
+
+// Store returns the underlying OCI store of the cache.
+func (m *Manager) Store() *oci.Store {
+	return m.store
+}
+
+// New generates a new target storage with caching, recording every access to a cached blob.
+//
+// Corrupt blobs found by WithVerify or WithVerifySampleRate are moved to the quarantine directory of the cache,
+// rather than deleted as with New.
+func (m *Manager) New(source oras.ReadOnlyTarget, opts ...Option) oras.ReadOnlyTarget {
+	return newTarget(source, m.store, m.Touch, m.quarantine, opts...)
+}
+
+// Touch marks the blob as used now.
+func (m *Manager) Touch(desc ocispec.Descriptor) error {
+	now := time.Now()
+	err := os.Chtimes(m.blobPath(desc), now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the number of bytes used by blobs in the cache, including quarantined blobs.
+func (m *Manager) Size() (int64, error) {
+	var size int64
+	for _, dir := range []string{ocispec.ImageBlobsDir, QuarantineDir} {
+		blobs, err := m.blobs(dir)
+		if err != nil {
+			return 0, err
+		}
+		for _, blob := range blobs {
+			size += blob.size
+		}
+	}
+	return size, nil
+}
+
+// Prune removes blobs older than the maximum age, then the least recently used blobs until the cache fits
+// in the maximum size, along with partially written blobs left behind by interrupted pushes.
+//
+// Quarantined blobs count towards the maximum size and are removed before any other blob when the cache is too
+// large. Their age is the time they were quarantined.
+//
+// Manifests tagged in the index.json of the cache, such as recorded resolutions, are never removed, nor is any
+// blob they reference.
+//
+// Only one process prunes the cache at a time, others wait for the lock until ctx is done.
+func (m *Manager) Prune(ctx context.Context) (PruneResult, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	defer unlock()
+
+	var result PruneResult
+	var errs []error
+	remove := func(path string, size int64, modTime time.Time) bool {
+		// skip blobs used since they were listed
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(modTime) {
+			return false
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			return false
+		}
+		result.Removed++
+		result.Freed += size
+		return true
+	}
+
+	quarantined, err := m.blobs(QuarantineDir)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	blobs, err := m.blobs(ocispec.ImageBlobsDir)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	tagged, err := m.tagged(ctx)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	slices.SortFunc(blobs, func(a, b blobInfo) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	// quarantined blobs are never read again, so they go first
+	candidates := append(quarantined, blobs...)
+
+	now := time.Now()
+	var total int64
+	for _, blob := range candidates {
+		total += blob.size
+	}
+	for _, blob := range candidates {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		expired := m.maxAge > 0 && now.Sub(blob.modTime) > m.maxAge
+		oversized := m.maxSize > 0 && total > m.maxSize
+		if !expired && !oversized {
+			continue
+		}
+		if tagged[blob.path] {
+			continue
+		}
+		if remove(blob.path, blob.size, blob.modTime) {
+			total -= blob.size
+		}
+	}
+
+	ingest, err := os.ReadDir(filepath.Join(m.dir, "ingest"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return result, err
+	}
+	for _, entry := range ingest {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || now.Sub(info.ModTime()) < staleIngestAge {
+			continue
+		}
+		remove(filepath.Join(m.dir, "ingest", entry.Name()), info.Size(), info.ModTime())
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// VerifyResult is the outcome of a Verify.
+type VerifyResult struct {
+	// Checked is the number of blobs verified
+	Checked int
+	// Corrupt lists the digests of the blobs that failed verification and were quarantined
+	Corrupt []digest.Digest
+}
+
+// Verify checks the digest of every blob in the cache, moving corrupt blobs to the quarantine directory.
+//
+// Verify takes the same lock as Prune, waiting for other processes until ctx is done.
+func (m *Manager) Verify(ctx context.Context) (VerifyResult, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer unlock()
+
+	blobs, err := m.blobs(ocispec.ImageBlobsDir)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	var result VerifyResult
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		rel, err := filepath.Rel(filepath.Join(m.dir, ocispec.ImageBlobsDir), blob.path)
+		if err != nil {
+			return result, err
+		}
+		desc := ocispec.Descriptor{
+			Digest: digest.Digest(strings.Replace(filepath.ToSlash(rel), "/", ":", 1)),
+			Size:   blob.size,
+		}
+		if desc.Digest.Validate() != nil {
+			// not a blob, leave it alone
+			continue
+		}
+		result.Checked++
+		ok, err := m.verifyBlob(blob.path, desc.Digest)
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			continue
+		}
+		if err := m.quarantine(ctx, desc); err != nil {
+			return result, err
+		}
+		result.Corrupt = append(result.Corrupt, desc.Digest)
+	}
+	return result, nil
+}
+
+// verifyBlob returns true if the content of the file at path matches dgst.
+func (m *Manager) verifyBlob(path string, dgst digest.Digest) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, f); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
+
+// quarantine moves a corrupt blob out of the cache into the quarantine directory.
+func (m *Manager) quarantine(_ context.Context, desc ocispec.Descriptor) error {
+	dir := filepath.Join(m.dir, QuarantineDir, desc.Digest.Algorithm().String())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, desc.Digest.Encoded())
+	err := os.Rename(m.blobPath(desc), path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// already quarantined by another reader
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Prune ages quarantined blobs from when they were quarantined
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// blobInfo is a blob in the cache directory.
+type blobInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// blobs lists every blob in the dir of the cache directory.
+func (m *Manager) blobs(dir string) ([]blobInfo, error) {
+	var blobs []blobInfo
+	root := filepath.Join(m.dir, dir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, blobInfo{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// tagged returns the paths of the blobs referenced by the index.json of the cache directory, along with every blob
+// reachable from them.
+//
+// The file is read rather than the index of the store, as other processes sharing the directory may have tagged
+// content since the store was opened.
+func (m *Manager) tagged(ctx context.Context) (map[string]bool, error) {
+	b, err := os.ReadFile(filepath.Join(m.dir, ocispec.ImageIndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("failed to read the index of cache %s: %w", m.dir, err)
+	}
+	tagged := map[string]bool{}
+	pending := index.Manifests
+	for len(pending) > 0 {
+		desc := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if desc.Digest.Validate() != nil || tagged[m.blobPath(desc)] {
+			continue
+		}
+		tagged[m.blobPath(desc)] = true
+		successors, err := content.Successors(ctx, m.store, desc)
+		if errors.Is(err, errdef.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the references of %s in cache %s: %w", desc.Digest, m.dir, err)
+		}
+		pending = append(pending, successors...)
+	}
+	return tagged, nil
+}
+
+// blobPath returns the path of the blob in the cache directory.
+func (m *Manager) blobPath(desc ocispec.Descriptor) string {
+	return filepath.Join(m.dir, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+}
+
+// lock takes the prune lock of the cache directory, waiting for other processes until ctx is done.
+func (m *Manager) lock(ctx context.Context) (func(), error) {
+	path := filepath.Join(m.dir, lockFile)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return m.holdLock(path, lockHeartbeatInterval), nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			m.breakStaleLock(path)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lock cache %s: %w", m.dir, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// holdLock touches the lock file at path every interval, so a long Prune or Verify is never mistaken for a crashed
+// process, and returns the function that releases the lock.
+func (m *Manager) holdLock(path string, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				_ = os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		_ = os.Remove(path)
+	}
+}
+
+// breakStaleLock removes the stale lock file at path.
+//
+// The lock is renamed to a unique name first, so only one process takes over a stale lock. If another process took
+// the lock between the staleness check and the rename, its lock is put back.
+func (m *Manager) breakStaleLock(path string) {
+	stale := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, stale); err != nil {
+		return
+	}
+	if info, err := os.Stat(stale); err == nil && time.Since(info.ModTime()) <= staleLockAge {
+		// a link fails rather than replace a lock taken since
+		_ = os.Link(stale, path)
+	}
+	_ = os.Remove(stale)
+}
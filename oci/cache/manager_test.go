@@ -0,0 +1,319 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+// pushBlob pushes b to the cache and marks it as last used at usedAt.
+func pushBlob(t *testing.T, m *Manager, b []byte, usedAt time.Time) ocispec.Descriptor {
+	t.Helper()
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	require.NoError(t, m.Store().Push(context.Background(), desc, bytes.NewReader(b)))
+	require.NoError(t, os.Chtimes(m.blobPath(desc), usedAt, usedAt))
+	return desc
+}
+
+func exists(t *testing.T, m *Manager, desc ocispec.Descriptor) bool {
+	t.Helper()
+	ok, err := m.Store().Exists(context.Background(), desc)
+	require.NoError(t, err)
+	return ok
+}
+
+func TestPruneMaxSize(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewManager(ctx, t.TempDir(), WithMaxSize(20))
+	require.NoError(t, err)
+
+	now := time.Now()
+	oldest := pushBlob(t, m, []byte("0123456789"), now.Add(-3*time.Hour))
+	middle := pushBlob(t, m, []byte("abcdefghij"), now.Add(-2*time.Hour))
+	newest := pushBlob(t, m, []byte("ABCDEFGHIJ"), now.Add(-time.Hour))
+
+	// reading a blob makes it the most recently used
+	require.NoError(t, m.Touch(oldest))
+
+	result, err := m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: 10}, result)
+	require.True(t, exists(t, m, oldest))
+	require.False(t, exists(t, m, middle))
+	require.True(t, exists(t, m, newest))
+
+	size, err := m.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(20), size)
+
+	result, err = m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{}, result)
+}
+
+func TestPruneMaxAge(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, err := NewManager(ctx, dir, WithMaxAge(time.Hour))
+	require.NoError(t, err)
+
+	now := time.Now()
+	expired := pushBlob(t, m, []byte("expired"), now.Add(-2*time.Hour))
+	fresh := pushBlob(t, m, []byte("fresh"), now)
+
+	// partially written blobs are removed once they are stale
+	ingest := filepath.Join(dir, "ingest")
+	require.NoError(t, os.MkdirAll(ingest, 0o700))
+	stale := filepath.Join(ingest, "stale")
+	require.NoError(t, os.WriteFile(stale, []byte("stale"), 0o600))
+	require.NoError(t, os.Chtimes(stale, now.Add(-2*staleIngestAge), now.Add(-2*staleIngestAge)))
+	inProgress := filepath.Join(ingest, "in-progress")
+	require.NoError(t, os.WriteFile(inProgress, []byte("in-progress"), 0o600))
+
+	result, err := m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 2, Freed: int64(len("expired") + len("stale"))}, result)
+	require.False(t, exists(t, m, expired))
+	require.True(t, exists(t, m, fresh))
+	require.NoFileExists(t, stale)
+	require.FileExists(t, inProgress)
+}
+
+func TestPruneLock(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(context.Background(), dir, WithMaxSize(1))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, lockFile), nil, 0o600))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*lockRetryInterval)
+	defer cancel()
+	_, err = m.Prune(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a lock left behind by a crashed process is taken over
+	stale := time.Now().Add(-2 * staleLockAge)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, lockFile), stale, stale))
+	_, err = m.Prune(context.Background())
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(dir, lockFile))
+	leftover, err := filepath.Glob(filepath.Join(dir, lockFile+".stale-*"))
+	require.NoError(t, err)
+	require.Empty(t, leftover)
+
+	// a lock taken by another process after it was found stale is put back
+	require.NoError(t, os.WriteFile(filepath.Join(dir, lockFile), nil, 0o600))
+	m.breakStaleLock(filepath.Join(dir, lockFile))
+	require.FileExists(t, filepath.Join(dir, lockFile))
+	leftover, err = filepath.Glob(filepath.Join(dir, lockFile+".stale-*"))
+	require.NoError(t, err)
+	require.Empty(t, leftover)
+
+	// a held lock is kept fresh until it is released
+	path := filepath.Join(dir, "held.lock")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	require.NoError(t, os.Chtimes(path, stale, stale))
+	unlock := m.holdLock(path, time.Millisecond)
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && time.Since(info.ModTime()) < staleLockAge
+	}, time.Second, time.Millisecond)
+	unlock()
+	require.NoFileExists(t, path)
+}
+
+func TestPruneKeepsTagged(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewManager(ctx, t.TempDir(), WithMaxAge(time.Hour))
+	require.NoError(t, err)
+
+	old := time.Now().Add(-2 * time.Hour)
+	config := pushBlob(t, m, []byte("{}"), old)
+	config.MediaType = ocispec.MediaTypeImageConfig
+	layer := pushBlob(t, m, []byte("layer"), old)
+	b, err := json.Marshal(ocispec.Manifest{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: []ocispec.Descriptor{layer}})
+	require.NoError(t, err)
+	manifest := content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, b)
+	require.NoError(t, m.Store().Push(ctx, manifest, bytes.NewReader(b)))
+	b, err = json.Marshal(ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{manifest}})
+	require.NoError(t, err)
+	index := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, b)
+	require.NoError(t, m.Store().Push(ctx, index, bytes.NewReader(b)))
+	require.NoError(t, m.Store().Tag(ctx, index, "example.com/repository:latest"))
+	for _, desc := range []ocispec.Descriptor{manifest, index} {
+		require.NoError(t, os.Chtimes(m.blobPath(desc), old, old))
+	}
+	untagged := pushBlob(t, m, []byte("untagged"), old)
+
+	// the tagged index and everything it references are kept
+	result, err := m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: untagged.Size}, result)
+	require.False(t, exists(t, m, untagged))
+	for _, desc := range []ocispec.Descriptor{config, layer, manifest, index} {
+		require.True(t, exists(t, m, desc))
+	}
+	tagged, err := m.Store().Resolve(ctx, "example.com/repository:latest")
+	require.NoError(t, err)
+	require.Equal(t, index.Digest, tagged.Digest)
+}
+
+func TestManagerTracksAccess(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewManager(ctx, t.TempDir())
+	require.NoError(t, err)
+
+	b := []byte("tracked")
+	source := memory.New()
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	require.NoError(t, source.Push(ctx, desc, bytes.NewReader(b)))
+	target := m.New(source)
+
+	// the first fetch fills the cache
+	rc, err := target.Fetch(ctx, desc)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.True(t, exists(t, m, desc))
+
+	// later fetches are served from the cache and mark the blob as used
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(m.blobPath(desc), past, past))
+	rc, err = target.Fetch(ctx, desc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	info, err := os.Stat(m.blobPath(desc))
+	require.NoError(t, err)
+	require.True(t, info.ModTime().After(past))
+}
+
+// corrupt overwrites the cached blob with content that does not match its digest.
+func corrupt(t *testing.T, m *Manager, desc ocispec.Descriptor) {
+	t.Helper()
+	path := m.blobPath(desc)
+	require.NoError(t, os.Chmod(path, 0o600))
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), int(desc.Size)), 0o600))
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, err := NewManager(ctx, dir)
+	require.NoError(t, err)
+
+	good := pushBlob(t, m, []byte("good"), time.Now())
+	bad := pushBlob(t, m, []byte("bad"), time.Now())
+	corrupt(t, m, bad)
+
+	result, err := m.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Checked)
+	require.Equal(t, []digest.Digest{bad.Digest}, result.Corrupt)
+	require.True(t, exists(t, m, good))
+	require.False(t, exists(t, m, bad))
+	require.FileExists(t, filepath.Join(dir, QuarantineDir, "sha256", bad.Digest.Encoded()))
+
+	result, err = m.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, VerifyResult{Checked: 1}, result)
+}
+
+func TestPruneQuarantined(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, err := NewManager(ctx, dir)
+	require.NoError(t, err)
+
+	good := pushBlob(t, m, []byte("good"), time.Now().Add(-time.Hour))
+	bad := pushBlob(t, m, []byte("bad"), time.Now().Add(-time.Hour))
+	corrupt(t, m, bad)
+	_, err = m.Verify(ctx)
+	require.NoError(t, err)
+
+	// quarantined blobs count towards the size of the cache
+	size, err := m.Size()
+	require.NoError(t, err)
+	require.Equal(t, good.Size+bad.Size, size)
+
+	// and are removed first even though they were quarantined after the last use of every other blob
+	m.maxSize = good.Size
+	result, err := m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: bad.Size}, result)
+	require.NoFileExists(t, filepath.Join(dir, QuarantineDir, "sha256", bad.Digest.Encoded()))
+	require.True(t, exists(t, m, good))
+
+	// they age from when they were quarantined
+	bad = pushBlob(t, m, []byte("bad"), time.Now().Add(-2*time.Hour))
+	corrupt(t, m, bad)
+	_, err = m.Verify(ctx)
+	require.NoError(t, err)
+	m.maxSize = 0
+	m.maxAge = 2 * time.Hour
+	result, err = m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{}, result)
+	quarantined := filepath.Join(dir, QuarantineDir, "sha256", bad.Digest.Encoded())
+	past := time.Now().Add(-3 * time.Hour)
+	require.NoError(t, os.Chtimes(quarantined, past, past))
+	result, err = m.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: bad.Size}, result)
+	require.NoFileExists(t, quarantined)
+}
+
+func TestVerifyOnRead(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, err := NewManager(ctx, dir)
+	require.NoError(t, err)
+
+	b := []byte("verified")
+	source := memory.New()
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayer, b)
+	require.NoError(t, source.Push(ctx, desc, bytes.NewReader(b)))
+	pushBlob(t, m, b, time.Now())
+	corrupt(t, m, desc)
+
+	// without verification the corrupt blob is served
+	rc, err := m.New(source).Fetch(ctx, desc)
+	require.NoError(t, err)
+	_, err = content.ReadAll(rc, desc)
+	require.ErrorIs(t, err, content.ErrMismatchedDigest)
+	require.NoError(t, rc.Close())
+
+	// with verification the blob is quarantined and fetched from the source again
+	rc, err = m.New(source, WithVerify()).Fetch(ctx, desc)
+	require.NoError(t, err)
+	got, err := content.ReadAll(rc, desc)
+	require.NoError(t, err)
+	require.Equal(t, b, got)
+	require.NoError(t, rc.Close())
+	require.FileExists(t, filepath.Join(dir, QuarantineDir, "sha256", desc.Digest.Encoded()))
+
+	// the cache is repaired
+	result, err := m.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, result.Corrupt)
+	rc, err = m.New(memory.New(), WithVerify()).Fetch(ctx, desc)
+	require.NoError(t, err)
+	got, err = content.ReadAll(rc, desc)
+	require.NoError(t, err)
+	require.Equal(t, b, got)
+	require.NoError(t, rc.Close())
+}
@@ -16,25 +16,42 @@ import (
 	"oras.land/oras-go/v2/registry"
 	"oras.land/oras-go/v2/registry/remote"
 	"oras.land/oras-go/v2/registry/remote/auth"
-	"oras.land/oras-go/v2/registry/remote/credentials"
 
 	"github.com/defenseunicorns/pkg/helpers/v2"
+	orasCache "github.com/defenseunicorns/pkg/oci/cache"
 )
 
 const (
 	// MultiOS is the OS used for multi-platform packages
 	MultiOS = "multi"
+
+	// defaultConcurrency is the default number of layers transferred in parallel
+	defaultConcurrency = 3
 )
 
 // OrasRemote is a wrapper around the Oras remote repository that includes a progress bar for interactive feedback.
 type OrasRemote struct {
-	repo               *remote.Repository
-	cache              *oci.Store
-	root               *Manifest
-	progTransport      *helpers.Transport
-	targetPlatform     *ocispec.Platform
-	insecureSkipVerify *bool
-	log                *slog.Logger
+	repo                *remote.Repository
+	cache               *oci.Store
+	cacheManager        *orasCache.Manager
+	cacheMode           cacheMode
+	cacheVerifyRate     float64
+	signer              *Signer
+	trustPolicy         *TrustPolicy
+	root                *Manifest
+	rootDesc            ocispec.Descriptor
+	progTransport       *helpers.Transport
+	targetPlatform      *ocispec.Platform
+	insecureSkipVerify  *bool
+	concurrency         int
+	indexUpdateAttempts int
+	uploadChunkSize     int
+	strictPaths         bool
+	credentialSources   []credentialSource
+	customDockerConfig  bool
+	rewrites            []rewrite
+	mirrors             map[string][]Mirror
+	log                 *slog.Logger
 }
 
 // Modifier is a function that modifies an OrasRemote
@@ -111,12 +128,53 @@ func WithLogger(logger *slog.Logger) Modifier {
 func WithCache(cache *oci.Store) Modifier {
 	return func(o *OrasRemote) {
 		o.cache = cache
+		o.cacheManager = nil
+	}
+}
+
+// WithCacheManager sets the cache for the remote to the cache managed by m, recording every access so Prune evicts
+// the least recently used blobs. A nil manager is ignored
+func WithCacheManager(m *orasCache.Manager) Modifier {
+	return func(o *OrasRemote) {
+		if m == nil {
+			return
+		}
+		o.cache = m.Store()
+		o.cacheManager = m
+	}
+}
+
+// WithCacheVerification verifies the digest of a fraction, between 0 and 1, of the blobs read from the cache.
+// Corrupt blobs are removed from the cache (or quarantined by a cache manager) and fetched from the registry again
+func WithCacheVerification(sampleRate float64) Modifier {
+	return func(o *OrasRemote) {
+		o.cacheVerifyRate = sampleRate
+	}
+}
+
+// WithConcurrency sets the maximum number of layers the remote transfers in parallel
+func WithConcurrency(concurrency int) Modifier {
+	return func(o *OrasRemote) {
+		if concurrency > 0 {
+			o.concurrency = concurrency
+		}
+	}
+}
+
+// WithIndexUpdateAttempts sets the maximum number of times UpdateIndex merges into an index that is being modified
+// concurrently before failing with ErrIndexConflict
+func WithIndexUpdateAttempts(attempts int) Modifier {
+	return func(o *OrasRemote) {
+		if attempts > 0 {
+			o.indexUpdateAttempts = attempts
+		}
 	}
 }
 
 // NewOrasRemote returns an oras remote repository client and context for the given url.
 //
-// Registry auth is handled by the Docker CLI's credential store and checked before returning the client
+// Registry auth is handled by the credential sources given as modifiers (see WithCredentials), tried in order,
+// followed by the Docker CLI's credential store unless WithDockerConfigPath is used
 func NewOrasRemote(url string, platform ocispec.Platform, mods ...Modifier) (*OrasRemote, error) {
 	ref, err := registry.ParseReference(strings.TrimPrefix(url, helpers.OCIURLPrefix))
 	if err != nil {
@@ -136,10 +194,14 @@ func NewOrasRemote(url string, platform ocispec.Platform, mods ...Modifier) (*Or
 		Cache: auth.NewCache(),
 	}
 	o := &OrasRemote{
-		repo:           &remote.Repository{Client: client},
-		progTransport:  progTransport,
-		targetPlatform: &platform,
-		log:            slog.Default(),
+		// many registries do not allow deletes, so keep replaced referrers indexes rather than failing the push
+		repo:                &remote.Repository{Client: client, SkipReferrersGC: true},
+		progTransport:       progTransport,
+		targetPlatform:      &platform,
+		concurrency:         defaultConcurrency,
+		indexUpdateAttempts: defaultIndexUpdateAttempts,
+		uploadChunkSize:     defaultUploadChunkSize,
+		log:                 slog.Default(),
 	}
 
 	for _, mod := range mods {
@@ -154,9 +216,17 @@ func NewOrasRemote(url string, platform ocispec.Platform, mods ...Modifier) (*Or
 }
 
 // SetProgressWriter sets the progress writer for the remote
+//
+// The writer is shared by concurrent transfers, calls to it are serialized.
 func (o *OrasRemote) SetProgressWriter(bar helpers.ProgressWriter) {
+	if _, ok := bar.(*syncProgressWriter); !ok && bar != nil {
+		bar = &syncProgressWriter{ProgressWriter: bar}
+	}
 	o.progTransport.ProgressBar = bar
-	client, ok := o.repo.Client.(*auth.Client)
+	if mirrors, ok := o.repo.Client.(*mirrorClient); ok {
+		mirrors.setProgressWriter(bar)
+	}
+	client, ok := o.authClient()
 	if ok {
 		client.Client.Transport = o.progTransport
 		return
@@ -169,7 +239,10 @@ func (o *OrasRemote) SetProgressWriter(bar helpers.ProgressWriter) {
 // ClearProgressWriter clears the progress writer for the remote
 func (o *OrasRemote) ClearProgressWriter() {
 	o.progTransport.ProgressBar = nil
-	client, ok := o.repo.Client.(*auth.Client)
+	if mirrors, ok := o.repo.Client.(*mirrorClient); ok {
+		mirrors.setProgressWriter(nil)
+	}
+	client, ok := o.authClient()
 	if ok {
 		client.Client.Transport = o.progTransport
 		return
@@ -189,31 +262,22 @@ func (o *OrasRemote) Log() *slog.Logger {
 	return o.log
 }
 
-// setRepository sets the repository for the remote as well as the auth client.
+// setRepository sets the repository for the remote as well as the auth client, applying any rewrite and mirror rules.
 func (o *OrasRemote) setRepository(ref registry.Reference) error {
 	o.root = nil
 
-	// patch docker.io to registry-1.docker.io
-	// this allows end users to use docker.io as an alias for registry-1.docker.io
-	if ref.Registry == "docker.io" {
-		ref.Registry = "registry-1.docker.io"
-	}
-	if ref.Registry == "🦄" || ref.Registry == "defenseunicorns" {
-		ref.Registry = "ghcr.io"
-		ref.Repository = "defenseunicorns/packages/" + ref.Repository
-	}
-	storeOpts := credentials.StoreOptions{}
-	credStore, err := credentials.NewStoreFromDocker(storeOpts)
+	ref = o.rewriteReference(ref)
+	credential, err := o.credentialChain(ref)
 	if err != nil {
 		return fmt.Errorf("failed to get credentials: %w", err)
 	}
-	client, ok := o.repo.Client.(*auth.Client)
+	client, ok := o.authClient()
 	if !ok {
 		return fmt.Errorf("repository client is not an auth client")
 	}
-	client.Credential = credentials.Credential(credStore)
-	if o.log != nil {
-		o.log.Debug("gathering credentials from default Docker config file", "credentials_configured", credStore.IsAuthConfigured())
+	client.Credential = credential
+	if err := o.setMirrors(ref, client); err != nil {
+		return err
 	}
 
 	o.repo.Reference = ref
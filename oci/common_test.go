@@ -201,3 +201,31 @@ func TestWithTransport_PreservesInsecureSkipVerifyWhenUnset(t *testing.T) {
 		})
 	}
 }
+
+func TestWithCacheManager_IgnoresNil(t *testing.T) {
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithCacheManager(nil))
+	require.NoError(t, err)
+	require.Nil(t, remote.cache)
+	require.Nil(t, remote.cacheManager)
+}
+
+func TestSetProgressWriter_SharedWithMirrors(t *testing.T) {
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch),
+		WithMirrors("example.com", Mirror{Endpoint: "mirror.example.com"}))
+	require.NoError(t, err)
+	remote.SetProgressWriter(&TestProgressWriter{})
+
+	// the writer is serialized once, for the remote and its mirrors alike
+	bar, ok := remote.progTransport.ProgressBar.(*syncProgressWriter)
+	require.True(t, ok)
+	mirrors, ok := remote.repo.Client.(*mirrorClient)
+	require.True(t, ok)
+	require.Len(t, mirrors.mirrors, 1)
+	require.Same(t, bar, mirrors.mirrors[0].transport.ProgressBar)
+
+	remote.SetProgressWriter(bar)
+	require.Same(t, bar, remote.progTransport.ProgressBar)
+	remote.ClearProgressWriter()
+	require.Nil(t, remote.progTransport.ProgressBar)
+	require.Nil(t, mirrors.mirrors[0].transport.ProgressBar)
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Compression is an algorithm layers are compressed with when they are pushed
+type Compression string
+
+const (
+	// CompressionNone pushes layers as they are
+	CompressionNone Compression = ""
+	// CompressionGzip compresses layers with gzip, adding the +gzip suffix to their media type
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses layers with zstd, adding the +zstd suffix to their media type
+	CompressionZstd Compression = "zstd"
+)
+
+// AnnotationUncompressedDigest is the annotation holding the digest of the uncompressed content of a compressed layer.
+//
+// Only layers with this annotation are decompressed when pulled.
+const AnnotationUncompressedDigest = "dev.defenseunicorns.uncompressed.digest"
+
+// AnnotationUncompressedSize is the annotation holding the size in bytes of the uncompressed content of a compressed layer.
+const AnnotationUncompressedSize = "dev.defenseunicorns.uncompressed.size"
+
+// WithPushCompression compresses the pushed file layers, see Compression.
+//
+// Compressed layers are decompressed by PullPath and PullPaths, which verify both the compressed and uncompressed digests.
+func WithPushCompression(compression Compression) PushOption {
+	return func(opts *pushOptions) {
+		opts.compression = compression
+	}
+}
+
+// compressedMediaType returns mediaType with the suffix of compression.
+func compressedMediaType(mediaType string, compression Compression) string {
+	if compression == CompressionNone {
+		return mediaType
+	}
+	return mediaType + "+" + string(compression)
+}
+
+// layerCompression returns the compression of a layer pushed with WithPushCompression, and the digest of its
+// uncompressed content.
+func layerCompression(desc ocispec.Descriptor) (Compression, digest.Digest) {
+	uncompressed := digest.Digest(desc.Annotations[AnnotationUncompressedDigest])
+	if uncompressed == "" {
+		return CompressionNone, ""
+	}
+	compression := mediaTypeCompression(desc.MediaType)
+	if compression == CompressionNone {
+		return CompressionNone, ""
+	}
+	return compression, uncompressed
+}
+
+// mediaTypeCompression returns the compression indicated by the suffix of mediaType.
+func mediaTypeCompression(mediaType string) Compression {
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		if strings.HasSuffix(mediaType, "+"+string(compression)) {
+			return compression
+		}
+	}
+	return CompressionNone
+}
+
+// compressWriter returns a writer compressing to w.
+//
+// The output only depends on the input, so pushing the same content twice results in the same layer.
+func compressWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// decompressReader returns a reader decompressing r.
+func decompressReader(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// compressTo compresses everything read from r to w.
+func compressTo(w io.Writer, r io.Reader, compression Compression) error {
+	cw, err := compressWriter(w, compression)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+// Write counts p.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
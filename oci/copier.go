@@ -4,110 +4,365 @@
 package oci
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sync/semaphore"
+	"oras.land/oras-go/v2/content"
 
 	"github.com/defenseunicorns/pkg/helpers/v2"
 )
 
+// CopyOption configures optional behavior of Copy
+type CopyOption func(*copyOptions)
+
+type copyOptions struct {
+	copyManifest bool
+	copyIndex    bool
+	platforms    []ocispec.Platform
+}
+
+// WithCopyManifest pushes the root manifest to the destination once its layers have been copied,
+// and merges it into the destination index under the source tag.
+//
+// If include filters out any layers the manifest is rewritten to only reference the copied layers.
+func WithCopyManifest() CopyOption {
+	return func(opts *copyOptions) {
+		opts.copyManifest = true
+	}
+}
+
+// WithCopyIndex copies every manifest in the source image index along with their layers,
+// then recreates the index at the destination under the source tag.
+//
+// When platforms are given only the matching manifests are copied, and the destination index only lists those.
+func WithCopyIndex(platforms ...ocispec.Platform) CopyOption {
+	return func(opts *copyOptions) {
+		opts.copyIndex = true
+		opts.platforms = platforms
+	}
+}
+
 // Copy copies an artifact from one OCI registry to another
+//
+// Up to concurrency layers are transferred in parallel, the first failure cancels any remaining transfers.
+// When src and dst share a registry, layers are mounted across repositories before falling back to streaming.
 func Copy(ctx context.Context, src *OrasRemote, dst *OrasRemote,
-	include func(d ocispec.Descriptor) bool, concurrency int, progressBar helpers.ProgressWriter) (err error) {
+	include func(d ocispec.Descriptor) bool, concurrency int, progressBar helpers.ProgressWriter, opts ...CopyOption) (err error) {
+	copyOpts := copyOptions{}
+	for _, opt := range opts {
+		opt(&copyOpts)
+	}
 	if progressBar == nil {
 		progressBar = helpers.DiscardProgressWriter{}
 	}
-	// create a new semaphore to limit concurrency
-	sem := semaphore.NewWeighted(int64(concurrency))
+	// layers complete out of order, so all progress updates must be serialized
+	progressBar = &syncProgressWriter{ProgressWriter: progressBar}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	start := time.Now()
+
+	if copyOpts.copyIndex {
+		err = copyIndex(ctx, src, dst, include, concurrency, progressBar, copyOpts.platforms)
+	} else {
+		err = copyRoot(ctx, src, dst, include, concurrency, progressBar, copyOpts.copyManifest)
+	}
+	if err != nil {
+		return err
+	}
+
+	duration := time.Since(start)
+	src.log.Debug("copy successful", "source", src.repo.Reference, "destination", dst.repo.Reference, "concurrency", concurrency, "duration", duration)
 
-	// fetch the source root manifest
-	srcRoot, err := src.FetchRoot(ctx)
+	return nil
+}
+
+// copyRoot copies the layers of the source root manifest, and optionally the manifest itself.
+func copyRoot(ctx context.Context, src *OrasRemote, dst *OrasRemote,
+	include func(d ocispec.Descriptor) bool, concurrency int, progressBar helpers.ProgressWriter, withManifest bool) error {
+	// the root is resolved once, so the manifest pushed is the one that was verified and whose layers were copied
+	srcRoot, rootDesc, err := src.fetchRoot(ctx)
 	if err != nil {
 		return err
 	}
 
-	layers := helpers.Filter(srcRoot.Layers, include)
-	layers = append(layers, srcRoot.Config)
+	filtered := helpers.Filter(srcRoot.Layers, include)
+	layers := append(slices.Clone(filtered), srcRoot.Config)
 
-	start := time.Now()
+	if err := copyLayers(ctx, src, dst, layers, concurrency, progressBar); err != nil {
+		return err
+	}
 
-	for idx, layer := range layers {
-		b, err := json.MarshalIndent(layer, "", "  ")
-		if err != nil {
-			src.log.Debug("failed to marshal json", "error", err.Error())
+	if !withManifest {
+		return nil
+	}
+
+	desc, err := pushManifest(ctx, src, dst, rootDesc, srcRoot, filtered)
+	if err != nil {
+		return err
+	}
+
+	// a digest reference has no tag to carry over, the manifest is resolvable by its digest alone
+	if src.repo.Reference.ValidateReferenceAsDigest() == nil {
+		return nil
+	}
+	return dst.updateIndex(ctx, src.repo.Reference.Reference, desc, src.targetPlatform)
+}
+
+// copyIndex copies every manifest in the source index that matches platforms, then recreates the index at the destination.
+func copyIndex(ctx context.Context, src *OrasRemote, dst *OrasRemote,
+	include func(d ocispec.Descriptor) bool, concurrency int, progressBar helpers.ProgressWriter, platforms []ocispec.Platform) error {
+	ref := src.repo.Reference.Reference
+	indexDesc, err := src.repo.Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if indexDesc.MediaType != ocispec.MediaTypeImageIndex {
+		return fmt.Errorf("%q resolved to %q, not an image index", ref, indexDesc.MediaType)
+	}
+	indexBytes, err := content.FetchAll(ctx, src.repo, indexDesc)
+	if err != nil {
+		return err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return err
+	}
+
+	manifestDescs := slices.Clone(index.Manifests)
+	if len(platforms) > 0 {
+		manifestDescs = helpers.Filter(manifestDescs, func(desc ocispec.Descriptor) bool {
+			return slices.ContainsFunc(platforms, func(platform ocispec.Platform) bool {
+				return platformMatches(platform, desc.Platform)
+			})
+		})
+		if len(manifestDescs) == 0 {
+			return fmt.Errorf("no manifests in %q match the requested platforms", ref)
 		}
-		src.log.Debug("Copying layer", "layer", string(b))
-		if err := sem.Acquire(ctx, 1); err != nil {
+	}
+
+	manifests := make([]*Manifest, len(manifestDescs))
+	manifestLayers := make([][]ocispec.Descriptor, len(manifestDescs))
+	layers := []ocispec.Descriptor{}
+	for idx, desc := range manifestDescs {
+		if desc.MediaType != ocispec.MediaTypeImageManifest {
+			return fmt.Errorf("unable to copy %s from %q: unsupported media type %q", desc.Digest, ref, desc.MediaType)
+		}
+		if err := src.verifyTrust(ctx, desc); err != nil {
+			return err
+		}
+		manifest, err := src.FetchManifest(ctx, desc)
+		if err != nil {
 			return err
 		}
+		manifests[idx] = manifest
+		manifestLayers[idx] = helpers.Filter(manifest.Layers, include)
+		layers = append(layers, manifestLayers[idx]...)
+		layers = append(layers, manifest.Config)
+	}
 
-		// check if the layer already exists in the destination
-		exists, err := dst.repo.Exists(ctx, layer)
+	// platforms commonly share layers, only copy each of them once
+	if err := copyLayers(ctx, src, dst, RemoveDuplicateDescriptors(layers), concurrency, progressBar); err != nil {
+		return err
+	}
+
+	rewritten := len(manifestDescs) != len(index.Manifests)
+	for idx, desc := range manifestDescs {
+		pushed, err := pushManifest(ctx, src, dst, desc, manifests[idx], manifestLayers[idx])
 		if err != nil {
 			return err
 		}
-		if exists {
-			src.log.Debug("layer already exists in destination, skipping")
-			b := make([]byte, layer.Size)
-			_, _ = progressBar.Write(b)
-			progressBar.Updatef("[%d/%d] layers copied", idx+1, len(layers))
-			sem.Release(1)
-			continue
+		if pushed.Digest != desc.Digest {
+			rewritten = true
 		}
+		manifestDescs[idx].Digest = pushed.Digest
+		manifestDescs[idx].Size = pushed.Size
+	}
 
-		eg, ectx := errgroup.WithContext(ctx)
-		eg.SetLimit(2)
-
-		// fetch the layer from the source
-		rc, err := src.repo.Fetch(ectx, layer)
+	// push the original bytes when nothing changed so the index digest is unchanged
+	if rewritten {
+		index.Manifests = manifestDescs
+		indexBytes, err = json.Marshal(index)
 		if err != nil {
 			return err
 		}
+	}
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, indexBytes)
+	if src.repo.Reference.ValidateReferenceAsDigest() == nil {
+		return dst.repo.Manifests().Push(ctx, desc, bytes.NewReader(indexBytes))
+	}
+	return dst.repo.Manifests().PushReference(ctx, desc, bytes.NewReader(indexBytes), ref)
+}
 
-		// create a new pipe so we can write to both the progressbar and the destination at the same time
-		pr, pw := io.Pipe()
+// copyLayers copies the given layers from src to dst, up to concurrency at a time.
+func copyLayers(ctx context.Context, src *OrasRemote, dst *OrasRemote, layers []ocispec.Descriptor, concurrency int, progressBar helpers.ProgressWriter) error {
+	// limit the number of layers being copied at once
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
 
-		// TeeReader gets the data from the fetching layer and writes it to the PipeWriter
-		tr := io.TeeReader(rc, pw)
+	var copied atomic.Int64
+	for _, layer := range layers {
+		b, err := json.MarshalIndent(layer, "", "  ")
+		if err != nil {
+			src.log.Debug("failed to marshal json", "error", err.Error())
+		}
+		src.log.Debug("Copying layer", "layer", string(b))
 
-		// this goroutine is responsible for pushing the layer to the destination
 		eg.Go(func() error {
-			defer pw.Close()
-
-			// get data from the TeeReader and push it to the destination
-			// push the layer to the destination
-			err = dst.repo.Push(ectx, layer, tr)
-			if err != nil {
-				return fmt.Errorf("failed to push layer %s to %s: %w", layer.Digest, dst.repo.Reference, err)
+			if err := copyLayer(ectx, src, dst, layer, progressBar); err != nil {
+				return err
 			}
+			progressBar.Updatef("[%d/%d] layers copied", copied.Add(1), len(layers))
 			return nil
 		})
+	}
 
-		// this goroutine is responsible for updating the progressbar
-		eg.Go(func() error {
-			// read from the PipeReader to the progressbar
-			if _, err := io.Copy(progressBar, pr); err != nil {
-				return fmt.Errorf("failed to update progress on layer %s: %w", layer.Digest, err)
-			}
-			return nil
-		})
+	// wait for all of the layers to finish, or for the first error
+	return eg.Wait()
+}
+
+// copyLayer streams a single layer from src to dst, writing the transferred bytes to the progress bar.
+func copyLayer(ctx context.Context, src *OrasRemote, dst *OrasRemote, layer ocispec.Descriptor, progressBar helpers.ProgressWriter) error {
+	// check if the layer already exists in the destination
+	exists, err := dst.repo.Exists(withoutMirrors(ctx), layer)
+	if err != nil {
+		return err
+	}
+	if exists {
+		src.log.Debug("layer already exists in destination, skipping", "digest", layer.Digest)
+		writeSkippedProgress(progressBar, layer.Size)
+		return nil
+	}
 
-		// wait for the goroutines to finish
-		if err := eg.Wait(); err != nil {
+	// layers within the same registry can be mounted without transferring any data
+	if src.repo.Reference.Registry == dst.repo.Reference.Registry {
+		mounted, err := mountLayer(ctx, src, dst, layer, progressBar)
+		if mounted || err != nil {
 			return err
 		}
-		sem.Release(1)
-		progressBar.Updatef("[%d/%d] layers copied", idx+1, len(layers))
 	}
 
-	duration := time.Since(start)
-	src.log.Debug("copy successful", "source", src.repo.Reference, "destination", dst.repo.Reference, "concurrency", concurrency, "duration", duration)
+	// fetch the layer from the source
+	rc, err := src.repo.Fetch(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
 
-	return nil
+	eg, ectx := errgroup.WithContext(ctx)
+
+	// create a new pipe so we can write to both the progressbar and the destination at the same time
+	pr, pw := io.Pipe()
+
+	// TeeReader gets the data from the fetching layer and writes it to the PipeWriter
+	tr := io.TeeReader(rc, pw)
+
+	// this goroutine is responsible for pushing the layer to the destination
+	eg.Go(func() error {
+		// get data from the TeeReader and push it to the destination
+		// closing with a nil error is the same as Close
+		err := dst.repo.Push(ectx, layer, tr)
+		pw.CloseWithError(err)
+		if err != nil {
+			return fmt.Errorf("failed to push layer %s to %s: %w", layer.Digest, dst.repo.Reference, err)
+		}
+		return nil
+	})
+
+	// this goroutine is responsible for updating the progressbar
+	eg.Go(func() error {
+		// read from the PipeReader to the progressbar
+		if _, err := io.Copy(progressBar, pr); err != nil {
+			pr.CloseWithError(err)
+			return fmt.Errorf("failed to update progress on layer %s: %w", layer.Digest, err)
+		}
+		return nil
+	})
+
+	// wait for the goroutines to finish
+	return eg.Wait()
+}
+
+// mountLayer attempts a cross-repository mount of the layer from src into dst.
+//
+// It returns false without an error when the registry refuses the mount request and the layer should be streamed instead.
+func mountLayer(ctx context.Context, src *OrasRemote, dst *OrasRemote, layer ocispec.Descriptor, progressBar helpers.ProgressWriter) (bool, error) {
+	// registries that support the mount request but can't mount the blob begin an upload session instead,
+	// in which case the content is streamed from the source as part of the same request
+	streamed := false
+	getContent := func() (io.ReadCloser, error) {
+		streamed = true
+		rc, err := src.repo.Fetch(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.TeeReader(rc, progressBar),
+			Closer: rc,
+		}, nil
+	}
+
+	err := dst.repo.Mount(ctx, layer, src.repo.Reference.Repository, getContent)
+	if err != nil {
+		if streamed {
+			return false, fmt.Errorf("failed to push layer %s to %s: %w", layer.Digest, dst.repo.Reference, err)
+		}
+		src.log.Debug("unable to mount layer, falling back to streaming", "digest", layer.Digest, "error", err.Error())
+		return false, nil
+	}
+	if !streamed {
+		src.log.Debug("layer mounted from source repository", "digest", layer.Digest, "from", src.repo.Reference.Repository)
+		writeSkippedProgress(progressBar, layer.Size)
+	}
+	return true, nil
+}
+
+// pushManifest pushes the source manifest to the destination, rewriting it if any of its layers were filtered out.
+func pushManifest(ctx context.Context, src *OrasRemote, dst *OrasRemote, srcDesc ocispec.Descriptor, srcManifest *Manifest, layers []ocispec.Descriptor) (ocispec.Descriptor, error) {
+	var b []byte
+	var err error
+	if len(layers) == len(srcManifest.Layers) {
+		// push the original bytes so the manifest digest is unchanged
+		b, err = content.FetchAll(ctx, src.repo, srcDesc)
+	} else {
+		manifest := srcManifest.Manifest
+		manifest.Layers = layers
+		b, err = json.Marshal(manifest)
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	mediaType := srcManifest.MediaType
+	if mediaType == "" {
+		mediaType = ocispec.MediaTypeImageManifest
+	}
+	desc := content.NewDescriptorFromBytes(mediaType, b)
+	if err := dst.repo.Manifests().Push(ctx, desc, bytes.NewReader(b)); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to push manifest %s to %s: %w", desc.Digest, dst.repo.Reference, err)
+	}
+	return desc, nil
+}
+
+// platformMatches returns true if got satisfies the wanted platform, an empty variant matches any variant.
+func platformMatches(want ocispec.Platform, got *ocispec.Platform) bool {
+	if got == nil {
+		return false
+	}
+	if want.OS != got.OS || want.Architecture != got.Architecture {
+		return false
+	}
+	return want.Variant == "" || want.Variant == got.Variant
 }
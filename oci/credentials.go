@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+)
+
+// credentialSource is a named source of registry credentials, loaded once the repository reference is known.
+type credentialSource struct {
+	name string
+	load func(ref registry.Reference) (auth.CredentialFunc, error)
+}
+
+// WithCredentials uses the given username and password for the remote's registry.
+func WithCredentials(username, password string) Modifier {
+	return withCredentialSource("static credentials", func(ref registry.Reference) (auth.CredentialFunc, error) {
+		cred := auth.Credential{
+			Username: username,
+			Password: password,
+		}
+		return hostCredential(ref, cred), nil
+	})
+}
+
+// WithCredentialFunc uses fn to look up credentials for the registries the remote talks to.
+//
+// fn should return auth.EmptyCredential for registries it has no credentials for, so the next source is tried.
+func WithCredentialFunc(fn auth.CredentialFunc) Modifier {
+	return withCredentialSource("credential func", func(_ registry.Reference) (auth.CredentialFunc, error) {
+		return fn, nil
+	})
+}
+
+// WithDockerConfigPath looks up credentials in the Docker config file at path instead of the default Docker config file.
+func WithDockerConfigPath(path string) Modifier {
+	return func(o *OrasRemote) {
+		o.customDockerConfig = true
+		withCredentialSource(fmt.Sprintf("Docker config file %s", path), func(_ registry.Reference) (auth.CredentialFunc, error) {
+			store, err := credentials.NewStore(path, credentials.StoreOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return credentials.Credential(store), nil
+		})(o)
+	}
+}
+
+// WithBearerTokenFile uses the token in the file at path as a bearer token for the remote's registry.
+//
+// The file is read on every lookup so a token rotated on disk is picked up without recreating the remote.
+func WithBearerTokenFile(path string) Modifier {
+	return withCredentialSource(fmt.Sprintf("bearer token file %s", path), func(ref registry.Reference) (auth.CredentialFunc, error) {
+		return func(_ context.Context, hostport string) (auth.Credential, error) {
+			if hostport != ref.Host() {
+				return auth.EmptyCredential, nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return auth.EmptyCredential, err
+			}
+			return auth.Credential{AccessToken: strings.TrimSpace(string(b))}, nil
+		}, nil
+	})
+}
+
+// WithCredentialHelper looks up credentials with the docker-credential-<helper> binary on the PATH.
+func WithCredentialHelper(helper string) Modifier {
+	return withCredentialSource(fmt.Sprintf("credential helper docker-credential-%s", helper), func(_ registry.Reference) (auth.CredentialFunc, error) {
+		return credentials.Credential(credentials.NewNativeStore(helper)), nil
+	})
+}
+
+// withCredentialSource appends a credential source to the remote's chain.
+func withCredentialSource(name string, load func(ref registry.Reference) (auth.CredentialFunc, error)) Modifier {
+	return func(o *OrasRemote) {
+		o.credentialSources = append(o.credentialSources, credentialSource{name: name, load: load})
+	}
+}
+
+// hostCredential returns cred for the reference's registry host only.
+func hostCredential(ref registry.Reference, cred auth.Credential) auth.CredentialFunc {
+	return func(_ context.Context, hostport string) (auth.Credential, error) {
+		if hostport != ref.Host() {
+			return auth.EmptyCredential, nil
+		}
+		return cred, nil
+	}
+}
+
+// credentialChain loads the configured credential sources for ref, followed by the default Docker config file
+// unless another Docker config file was given, and returns a function that tries each in order.
+func (o *OrasRemote) credentialChain(ref registry.Reference) (auth.CredentialFunc, error) {
+	// the default source is appended to a copy, so remotes never share a backing array
+	sources := slices.Clone(o.credentialSources)
+	if !o.customDockerConfig {
+		sources = append(sources, credentialSource{
+			name: "default Docker config file",
+			load: func(_ registry.Reference) (auth.CredentialFunc, error) {
+				store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+				if err != nil {
+					return nil, err
+				}
+				if o.log != nil {
+					o.log.Debug("gathering credentials from default Docker config file", "credentials_configured", store.IsAuthConfigured())
+				}
+				return credentials.Credential(store), nil
+			},
+		})
+	}
+
+	funcs := make([]auth.CredentialFunc, len(sources))
+	for idx, source := range sources {
+		fn, err := source.load(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials from %s: %w", source.name, err)
+		}
+		funcs[idx] = fn
+	}
+
+	return func(ctx context.Context, hostport string) (auth.Credential, error) {
+		for idx, fn := range funcs {
+			cred, err := fn(ctx, hostport)
+			if err != nil {
+				return auth.EmptyCredential, fmt.Errorf("failed to get credentials from %s: %w", sources[idx].name, err)
+			}
+			if cred == auth.EmptyCredential {
+				continue
+			}
+			if o.log != nil {
+				o.log.Debug("using registry credentials", "registry", hostport, "source", sources[idx].name)
+			}
+			return cred, nil
+		}
+		return auth.EmptyCredential, nil
+	}, nil
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote/auth"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func credentialFor(t *testing.T, remote *OrasRemote, hostport string) (auth.Credential, error) {
+	t.Helper()
+	client, ok := remote.repo.Client.(*auth.Client)
+	require.True(t, ok)
+	return client.Credential(context.Background(), hostport)
+}
+
+func TestWithCredentials(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch),
+		WithCredentials("user", "pass"), WithLogger(logger))
+	require.NoError(t, err)
+
+	cred, err := credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, auth.Credential{Username: "user", Password: "pass"}, cred)
+	require.Contains(t, logs.String(), `"source":"static credentials"`)
+
+	// credentials are never sent to other registries
+	cred, err = credentialFor(t, remote, "other.example.com")
+	require.NoError(t, err)
+	require.Equal(t, auth.EmptyCredential, cred)
+}
+
+func TestCredentialChainOrder(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	calls := 0
+	empty := func(_ context.Context, _ string) (auth.Credential, error) {
+		calls++
+		return auth.EmptyCredential, nil
+	}
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch),
+		WithCredentialFunc(empty), WithCredentials("user", "pass"), WithCredentials("other", "pass"))
+	require.NoError(t, err)
+	cred, err := credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, "user", cred.Username)
+
+	failing := func(_ context.Context, _ string) (auth.Credential, error) {
+		return auth.EmptyCredential, errors.New("lookup failed")
+	}
+	remote, err = NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithCredentialFunc(failing))
+	require.NoError(t, err)
+	_, err = credentialFor(t, remote, "example.com")
+	require.ErrorContains(t, err, "credential func")
+}
+
+func TestCredentialChainSharedSources(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	o := &OrasRemote{credentialSources: make([]credentialSource, 0, 2)}
+	WithCredentials("user", "pass")(o)
+	_, err := o.credentialChain(registry.Reference{Registry: "example.com", Repository: "repository"})
+	require.NoError(t, err)
+	// the default Docker config file is not written to the spare capacity of the configured sources
+	require.Empty(t, o.credentialSources[:2][1].name)
+}
+
+func TestWithDockerConfigPath(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	encoded := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	config := fmt.Sprintf(`{"auths":{"example.com":{"auth":%q}}}`, encoded)
+	require.NoError(t, os.WriteFile(configPath, []byte(config), helpers.ReadWriteUser))
+
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithDockerConfigPath(configPath))
+	require.NoError(t, err)
+	cred, err := credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, auth.Credential{Username: "user", Password: "pass"}, cred)
+
+	require.NoError(t, os.WriteFile(configPath, []byte("not json"), helpers.ReadWriteUser))
+	_, err = NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithDockerConfigPath(configPath))
+	require.Error(t, err)
+}
+
+func TestWithBearerTokenFile(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("first-token\n"), helpers.ReadWriteUser))
+
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithBearerTokenFile(tokenPath))
+	require.NoError(t, err)
+	cred, err := credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, auth.Credential{AccessToken: "first-token"}, cred)
+
+	// a rotated token is picked up on the next lookup
+	require.NoError(t, os.WriteFile(tokenPath, []byte("second-token"), helpers.ReadWriteUser))
+	cred, err = credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, "second-token", cred.AccessToken)
+}
+
+func TestWithCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper script requires a POSIX shell")
+	}
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nread server\necho '{\"ServerURL\":\"'$server'\",\"Username\":\"helper-user\",\"Secret\":\"helper-pass\"}'\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-test"), []byte(script), 0o700))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	remote, err := NewOrasRemote("example.com/repository:latest", PlatformForArch(testArch), WithCredentialHelper("test"))
+	require.NoError(t, err)
+	cred, err := credentialFor(t, remote, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, auth.Credential{Username: "helper-user", Password: "helper-pass"}, cred)
+}
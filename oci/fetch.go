@@ -19,7 +19,29 @@ import (
 )
 
 // ResolveRoot returns the root descriptor for the remote repository
+//
+// With WithPreferCache the resolution is recorded in the cache, so it can be served by later remotes with WithOffline
+// and WithPreferCache.
 func (o *OrasRemote) ResolveRoot(ctx context.Context) (ocispec.Descriptor, error) {
+	if o.cacheMode != cacheModeDefault {
+		desc, err := o.resolveCached(ctx)
+		if err == nil || o.cacheMode == cacheModeOffline {
+			return desc, err
+		}
+	}
+
+	desc, err := o.resolveRemote(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if o.cacheMode == cacheModePreferCache {
+		o.recordResolution(ctx, desc)
+	}
+	return desc, nil
+}
+
+// resolveRemote resolves the root descriptor against the registry
+func (o *OrasRemote) resolveRemote(ctx context.Context) (ocispec.Descriptor, error) {
 	// first try to resolve the reference into an OCI descriptor directly
 	desc, err := o.repo.Resolve(ctx, o.repo.Reference.Reference)
 	// if we succeeded and it's not an index, return it
@@ -44,22 +66,34 @@ func (o *OrasRemote) ResolveRoot(ctx context.Context) (ocispec.Descriptor, error
 
 // FetchRoot fetches the root manifest from the remote repository.
 func (o *OrasRemote) FetchRoot(ctx context.Context) (*Manifest, error) {
+	root, _, err := o.fetchRoot(ctx)
+	return root, err
+}
+
+// fetchRoot fetches the root manifest from the remote repository, along with the descriptor it was resolved to.
+func (o *OrasRemote) fetchRoot(ctx context.Context) (*Manifest, ocispec.Descriptor, error) {
 	if o.root != nil {
-		return o.root, nil
+		return o.root, o.rootDesc, nil
 	}
 	// get the manifest descriptor
 	descriptor, err := o.ResolveRoot(ctx)
 	if err != nil {
-		return nil, err
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	// verify the manifest is signed by a trusted key before anything else is fetched
+	if err := o.verifyTrust(ctx, descriptor); err != nil {
+		return nil, ocispec.Descriptor{}, err
 	}
 
 	// fetch the manifest
 	root, err := o.FetchManifest(ctx, descriptor)
 	if err != nil {
-		return nil, err
+		return nil, ocispec.Descriptor{}, err
 	}
 	o.root = root
-	return o.root, nil
+	o.rootDesc = descriptor
+	return o.root, o.rootDesc, nil
 }
 
 // FetchManifest fetches the manifest with the given descriptor from the remote repository.
@@ -68,12 +102,23 @@ func (o *OrasRemote) FetchManifest(ctx context.Context, desc ocispec.Descriptor)
 }
 
 // src returns the read target for layer fetches, wrapping the repository with the
-// layer cache when one is configured.
+// layer cache when one is configured. When offline the cache wraps a target that has no content instead.
 func (o *OrasRemote) src() oras.ReadOnlyTarget {
+	var source oras.ReadOnlyTarget = o.repo
+	if o.cacheMode == cacheModeOffline {
+		if o.cache == nil {
+			return offlineTarget{}
+		}
+		source = offlineTarget{cache: o.cache}
+	}
+	opts := []orasCache.Option{orasCache.WithVerifySampleRate(o.cacheVerifyRate)}
+	if o.cacheManager != nil {
+		return o.cacheManager.New(source, opts...)
+	}
 	if o.cache != nil {
-		return orasCache.New(o.repo, o.cache)
+		return orasCache.New(source, o.cache, opts...)
 	}
-	return o.repo
+	return source
 }
 
 // Fetch fetches the content for the given descriptor, honoring the layer cache
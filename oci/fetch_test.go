@@ -6,14 +6,20 @@ package oci
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2/content/file"
 	ocistore "oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
 
 	"github.com/defenseunicorns/pkg/helpers/v2"
+	orasCache "github.com/defenseunicorns/pkg/oci/cache"
 )
 
 type cachePayload struct {
@@ -122,3 +128,156 @@ func (suite *OCISuite) TestFetchersUseCache() {
 	suite.NoError(err)
 	suite.Equal(warmUn, offlineUn)
 }
+
+func (suite *OCISuite) TestOffline() {
+	ctx := context.TODO()
+
+	srcTempDir := suite.T().TempDir()
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	var descs []ocispec.Descriptor
+	for _, name := range []string{"offline-a", "offline-b"} {
+		path := filepath.Join(srcTempDir, name)
+		suite.NoError(os.WriteFile(path, []byte(name), helpers.ReadWriteUser))
+		desc, err := src.Add(ctx, name, ocispec.MediaTypeImageLayer, path)
+		suite.NoError(err)
+		descs = append(descs, desc)
+	}
+	suite.publishPackage(src, descs)
+
+	url := "oci://" + suite.remote.Repo().Reference.String()
+	store, err := ocistore.New(suite.T().TempDir())
+	suite.NoError(err)
+	// resolutions are only recorded when preferring the cache
+	cached, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store))
+	suite.NoError(err)
+	_, err = cached.ResolveRoot(ctx)
+	suite.NoError(err)
+	_, err = store.Resolve(ctx, cached.resolutionKey())
+	suite.ErrorIs(err, errdef.ErrNotFound)
+
+	warm, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store), WithPreferCache())
+	suite.NoError(err)
+	rootDesc, err := warm.ResolveRoot(ctx)
+	suite.NoError(err)
+
+	// the registry is unreachable from the offline remote
+	unreachable := &http.Transport{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return nil, errors.New("network is unreachable")
+		},
+	}
+	offline, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store), WithOffline(), WithTransport(unreachable))
+	suite.NoError(err)
+	desc, err := offline.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(rootDesc, desc)
+	root, err := offline.FetchRoot(ctx)
+	suite.NoError(err)
+
+	// layers that were never pulled are reported by name
+	_, err = offline.FetchLayer(ctx, root.Locate("offline-a"))
+	suite.ErrorIs(err, ErrNotCached)
+	suite.ErrorContains(err, "offline-a")
+	_, err = offline.PullPaths(ctx, suite.T().TempDir(), []string{"offline-a", "offline-b"})
+	suite.ErrorIs(err, ErrNotCached)
+
+	_, err = warm.PullPaths(ctx, suite.T().TempDir(), []string{"offline-a", "offline-b"})
+	suite.NoError(err)
+	dir := suite.T().TempDir()
+	pulled, err := offline.PullPaths(ctx, dir, []string{"offline-a", "offline-b"})
+	suite.NoError(err)
+	suite.Len(pulled, 2)
+	b, err := os.ReadFile(filepath.Join(dir, "offline-b"))
+	suite.NoError(err)
+	suite.Equal("offline-b", string(b))
+
+	// resolutions are recorded per platform
+	other, err := NewOrasRemote(url, PlatformForArch("other-arch"), WithCache(store), WithOffline())
+	suite.NoError(err)
+	_, err = other.ResolveRoot(ctx)
+	suite.ErrorIs(err, ErrNotCached)
+	suite.ErrorContains(err, "multi/other-arch")
+
+	noCache, err := NewOrasRemote(url, PlatformForArch(testArch), WithOffline())
+	suite.NoError(err)
+	_, err = noCache.FetchRoot(ctx)
+	suite.ErrorContains(err, "requires a cache")
+}
+
+func (suite *OCISuite) TestOfflineCacheManager() {
+	ctx := context.TODO()
+
+	srcTempDir := suite.T().TempDir()
+	path := filepath.Join(srcTempDir, "offline-managed")
+	suite.NoError(os.WriteFile(path, []byte("offline-managed"), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	desc, err := src.Add(ctx, "offline-managed", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+	url := "oci://" + suite.remote.Repo().Reference.String()
+	cacheDir := suite.T().TempDir()
+	manager, err := orasCache.NewManager(ctx, cacheDir)
+	suite.NoError(err)
+	warm, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCacheManager(manager), WithPreferCache())
+	suite.NoError(err)
+	_, err = warm.PullPaths(ctx, suite.T().TempDir(), []string{"offline-managed"})
+	suite.NoError(err)
+
+	// reads served offline are recorded as accesses of the cache
+	blob := filepath.Join(cacheDir, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	old := time.Now().Add(-time.Hour)
+	suite.NoError(os.Chtimes(blob, old, old))
+	offline, err := NewOrasRemote(url, PlatformForArch(testArch), WithCacheManager(manager), WithOffline())
+	suite.NoError(err)
+	root, err := offline.FetchRoot(ctx)
+	suite.NoError(err)
+	b, err := offline.FetchLayer(ctx, root.Locate("offline-managed"))
+	suite.NoError(err)
+	suite.Equal("offline-managed", string(b))
+	info, err := os.Stat(blob)
+	suite.NoError(err)
+	suite.True(info.ModTime().After(old))
+}
+
+func (suite *OCISuite) TestPreferCache() {
+	ctx := context.TODO()
+
+	srcTempDir := suite.T().TempDir()
+	path := filepath.Join(srcTempDir, "prefer-cache")
+	suite.NoError(os.WriteFile(path, []byte("first"), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	desc, err := src.Add(ctx, "prefer-cache", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+	url := "oci://" + suite.remote.Repo().Reference.String()
+	store, err := ocistore.New(suite.T().TempDir())
+	suite.NoError(err)
+	// nothing is cached yet, so the reference is resolved against the registry
+	prefer, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store), WithPreferCache())
+	suite.NoError(err)
+	first, err := prefer.ResolveRoot(ctx)
+	suite.NoError(err)
+
+	// the tag is moved, but the cached resolution is preferred
+	secondTempDir := suite.T().TempDir()
+	path = filepath.Join(secondTempDir, "prefer-cache")
+	suite.NoError(os.WriteFile(path, []byte("second"), helpers.ReadWriteUser))
+	second, err := file.New(secondTempDir)
+	suite.NoError(err)
+	desc, err = second.Add(ctx, "prefer-cache", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(second, []ocispec.Descriptor{desc})
+	latest, err := suite.remote.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.NotEqual(first.Digest, latest.Digest)
+	prefer, err = NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store), WithPreferCache())
+	suite.NoError(err)
+	desc, err = prefer.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(first.Digest, desc.Digest)
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// FS returns a read-only file system over the layers of the root manifest, named by their title annotations.
+//
+// Slash-separated titles are presented as a tree of directories. Layers without a valid title (see fs.ValidPath), or
+// whose title is already used by another layer or directory, are left out. A layer is only fetched when its file is
+// opened, through the layer cache when one is configured, and is verified once read to the end. Compressed layers
+// (see WithPushCompression) are decompressed, and report the size recorded in AnnotationUncompressedSize when they
+// were pushed, or -1 when it is unknown.
+//
+// Directory layers (see AnnotationUnpack) are not unpacked: each is presented as a single file holding its tar
+// archive, named by its title, so the files within it can't be opened through the file system.
+//
+// ctx is used for every fetch made through the file system.
+func (o *OrasRemote) FS(ctx context.Context) (fs.ReadDirFS, error) {
+	root, err := o.FetchRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := &remoteFS{
+		ctx:      ctx,
+		remote:   o,
+		manifest: root,
+		dirs:     map[string][]fs.DirEntry{".": nil},
+	}
+	files := map[string]bool{}
+	for _, layer := range root.Layers {
+		title := layer.Annotations[ocispec.AnnotationTitle]
+		if !fs.ValidPath(title) || title == "." || files[title] || fsys.dirs[title] != nil {
+			continue
+		}
+		// lookups go through Locate, so only the layer it finds for the title is presented
+		if located := root.Locate(title); located.Digest != layer.Digest || located.Annotations[ocispec.AnnotationTitle] != title {
+			continue
+		}
+
+		// the parent directories that do not exist yet, from the closest to the root
+		var parents []string
+		conflict := false
+		for dir := path.Dir(title); dir != "."; dir = path.Dir(dir) {
+			if files[dir] {
+				conflict = true
+				break
+			}
+			if _, ok := fsys.dirs[dir]; ok {
+				break
+			}
+			parents = append(parents, dir)
+		}
+		if conflict {
+			continue
+		}
+		for _, dir := range slices.Backward(parents) {
+			fsys.dirs[dir] = []fs.DirEntry{}
+			fsys.addEntry(&remoteFileInfo{name: path.Base(dir), dir: true}, path.Dir(dir))
+		}
+		files[title] = true
+		fsys.addEntry(&remoteFileInfo{name: path.Base(title), size: fileSize(layer)}, path.Dir(title))
+	}
+	for _, entries := range fsys.dirs {
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	return fsys, nil
+}
+
+// fileSize returns the size of the content of layer once decompressed, or -1 if it is unknown.
+func fileSize(layer ocispec.Descriptor) int64 {
+	if compression, _ := layerCompression(layer); compression == CompressionNone {
+		return layer.Size
+	}
+	size, err := strconv.ParseInt(layer.Annotations[AnnotationUncompressedSize], 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+// remoteFS is the file system returned by FS.
+type remoteFS struct {
+	// ctx is kept as fs.FS methods have no context
+	ctx      context.Context
+	remote   *OrasRemote
+	manifest *Manifest
+	// dirs holds the entries of every directory, keyed by its path
+	dirs map[string][]fs.DirEntry
+}
+
+// addEntry adds entry to the directory dir.
+func (fsys *remoteFS) addEntry(entry *remoteFileInfo, dir string) {
+	fsys.dirs[dir] = append(fsys.dirs[dir], entry)
+}
+
+// Open opens the named file or directory, fetching the layer of a file.
+func (fsys *remoteFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if entries, ok := fsys.dirs[name]; ok {
+		return &remoteDir{info: &remoteFileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+	}
+	layer, err := fsys.layer(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	rc, err := fsys.remote.Fetch(fsys.ctx, layer)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	vr := content.NewVerifyReader(rc, layer)
+	file := &remoteFile{
+		info:   &remoteFileInfo{name: path.Base(name), size: fileSize(layer)},
+		r:      vr,
+		verify: vr.Verify,
+		close:  rc.Close,
+	}
+	compression, uncompressed := layerCompression(layer)
+	if compression == CompressionNone {
+		return file, nil
+	}
+	if err := uncompressed.Validate(); err != nil {
+		return nil, errors.Join(&fs.PathError{Op: "open", Path: name, Err: err}, rc.Close())
+	}
+	dr, err := decompressReader(vr, compression)
+	if err != nil {
+		return nil, errors.Join(&fs.PathError{Op: "open", Path: name, Err: err}, rc.Close())
+	}
+	digester := uncompressed.Algorithm().Digester()
+	file.r = io.TeeReader(dr, digester.Hash())
+	file.verify = func() error {
+		if err := vr.Verify(); err != nil {
+			return err
+		}
+		if digester.Digest() != uncompressed {
+			return fmt.Errorf("uncompressed %s: %w", uncompressed, content.ErrMismatchedDigest)
+		}
+		return nil
+	}
+	file.close = func() error {
+		return errors.Join(dr.Close(), rc.Close())
+	}
+	return file, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (fsys *remoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok := fsys.dirs[name]
+	if !ok {
+		if _, err := fsys.layer(name); err == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return slices.Clone(entries), nil
+}
+
+// layer returns the layer titled name.
+func (fsys *remoteFS) layer(name string) (ocispec.Descriptor, error) {
+	layer := fsys.manifest.Locate(name)
+	if IsEmptyDescriptor(layer) || layer.Annotations[ocispec.AnnotationTitle] != name {
+		return ocispec.Descriptor{}, fs.ErrNotExist
+	}
+	// the title may be hidden by a directory or by a file in place of one of its parents
+	if _, ok := fsys.dirs[path.Dir(name)]; !ok {
+		return ocispec.Descriptor{}, fs.ErrNotExist
+	}
+	return layer, nil
+}
+
+// remoteFile is a layer opened from a remoteFS.
+type remoteFile struct {
+	info   *remoteFileInfo
+	r      io.Reader
+	verify func() error
+	close  func() error
+}
+
+// Stat returns the file info of the layer.
+func (f *remoteFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read reads the layer, verifying its digest once it has been read to the end.
+func (f *remoteFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		if verifyErr := f.verify(); verifyErr != nil {
+			return n, &fs.PathError{Op: "read", Path: f.info.name, Err: verifyErr}
+		}
+	}
+	return n, err
+}
+
+// Close closes the fetch of the layer.
+func (f *remoteFile) Close() error {
+	return f.close()
+}
+
+// remoteDir is a directory opened from a remoteFS.
+type remoteDir struct {
+	info    *remoteFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+// Stat returns the file info of the directory.
+func (d *remoteDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read fails, as directories cannot be read.
+func (d *remoteDir) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// Close does nothing.
+func (d *remoteDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining entries if n <= 0.
+func (d *remoteDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return slices.Clone(remaining), nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return slices.Clone(remaining[:n]), nil
+}
+
+// remoteFileInfo describes a file or directory of a remoteFS, as both an fs.FileInfo and an fs.DirEntry.
+type remoteFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+// Name returns the base name of the file or directory.
+func (i *remoteFileInfo) Name() string { return i.name }
+
+// Size returns the size of the file, see fileSize.
+func (i *remoteFileInfo) Size() int64 { return i.size }
+
+// Mode returns read-only permissions for everyone.
+func (i *remoteFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// ModTime returns the zero time, as layers have no modification time.
+func (i *remoteFileInfo) ModTime() time.Time { return time.Time{} }
+
+// IsDir reports whether the entry is a directory.
+func (i *remoteFileInfo) IsDir() bool { return i.dir }
+
+// Sys returns nil.
+func (i *remoteFileInfo) Sys() any { return nil }
+
+// Type returns the type bits of the mode.
+func (i *remoteFileInfo) Type() fs.FileMode { return i.Mode().Type() }
+
+// Info returns the file info of the entry.
+func (i *remoteFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+var _ fs.ReadDirFile = (*remoteDir)(nil)
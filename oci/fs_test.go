@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	ocistore "oras.land/oras-go/v2/content/oci"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func (suite *OCISuite) TestFS() {
+	ctx := context.TODO()
+	url := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+
+	files := map[string]string{
+		"zarf.yaml":                       "kind: {{ .Kind }}",
+		"components/first.tar":            "first component",
+		"components/templates/second.tpl": "second {{ .Kind }}",
+		"images/index.json":               "{}",
+	}
+	srcDir := suite.T().TempDir()
+	for name, contents := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		suite.NoError(helpers.CreateDirectory(filepath.Dir(path), helpers.ReadExecuteAllWriteUser))
+		suite.NoError(os.WriteFile(path, []byte(contents), helpers.ReadWriteUser))
+	}
+
+	for _, compression := range []Compression{CompressionNone, CompressionZstd} {
+		store, err := ocistore.New(suite.T().TempDir())
+		suite.NoError(err)
+		remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store))
+		suite.NoError(err)
+		_, err = remote.PushDirectory(ctx, "0.0.1", srcDir, WithPushCompression(compression))
+		suite.NoError(err)
+
+		fsys, err := remote.FS(ctx)
+		suite.NoError(err)
+		suite.NoError(fstest.TestFS(fsys, "zarf.yaml", "components/first.tar", "components/templates/second.tpl", "images/index.json"))
+
+		walked := map[string]string{}
+		err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := fs.ReadFile(fsys, path)
+			walked[path] = string(b)
+			return err
+		})
+		suite.NoError(err)
+		suite.Equal(files, walked)
+		// compressed layers report the size of their content
+		for name, contents := range files {
+			info, err := fs.Stat(fsys, name)
+			suite.NoError(err)
+			suite.Equal(int64(len(contents)), info.Size(), name)
+		}
+		// layers are fetched through the cache
+		root, err := remote.FetchRoot(ctx)
+		suite.NoError(err)
+		cached, err := store.Exists(ctx, root.Locate("zarf.yaml"))
+		suite.NoError(err)
+		suite.True(cached)
+
+		tmpl, err := template.ParseFS(fsys, "zarf.yaml", "components/templates/*.tpl")
+		suite.NoError(err)
+		var buf bytes.Buffer
+		suite.NoError(tmpl.ExecuteTemplate(&buf, "second.tpl", map[string]string{"Kind": "ZarfPackageConfig"}))
+		suite.Equal("second ZarfPackageConfig", buf.String())
+
+		_, err = fsys.Open("missing.yaml")
+		suite.ErrorIs(err, fs.ErrNotExist)
+		_, err = fsys.ReadDir("zarf.yaml")
+		suite.Error(err)
+		_, err = fsys.Open("../zarf.yaml")
+		suite.ErrorIs(err, fs.ErrInvalid)
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		layer    ocispec.Descriptor
+		expected int64
+	}{
+		{name: "uncompressed", layer: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Size: 10}, expected: 10},
+		{
+			name: "compressed",
+			layer: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Size: 10, Annotations: map[string]string{
+				AnnotationUncompressedDigest: digest.FromString("content").String(),
+				AnnotationUncompressedSize:   "7",
+			}},
+			expected: 7,
+		},
+		{
+			name: "compressed without size",
+			layer: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Size: 10, Annotations: map[string]string{
+				AnnotationUncompressedDigest: digest.FromString("content").String(),
+			}},
+			expected: -1,
+		},
+		// layers compressed without an uncompressed digest are presented as is
+		{name: "compressed by another tool", layer: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Size: 10}, expected: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, fileSize(tt.layer))
+		})
+	}
+}
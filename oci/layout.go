@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/errdef"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+// ExportLayout writes the artifact to an OCI image layout directory at dir and returns the exported root descriptor.
+//
+// When allPlatforms is true the image index the reference points to is exported with every platform,
+// otherwise only the manifest for the target platform is exported. The root is tagged in the layout with the remote's reference.
+func (o *OrasRemote) ExportLayout(ctx context.Context, dir string, allPlatforms bool) (ocispec.Descriptor, error) {
+	root, err := o.resolveExportRoot(ctx, allPlatforms)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	store, err := oci.NewWithContext(ctx, dir)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	copyOpts := o.GetDefaultCopyOpts()
+	copyOpts.Concurrency = o.concurrency
+	if err := oras.CopyGraph(ctx, o.src(), store, root, copyOpts.CopyGraphOptions); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if err := store.Tag(ctx, root, o.repo.Reference.Reference); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	// the store leaves behind an empty directory for in-progress blobs
+	_ = os.Remove(filepath.Join(dir, "ingest"))
+
+	return root, nil
+}
+
+// ExportTarball writes the artifact to a single OCI image layout tarball at tarballPath and returns the exported root descriptor.
+//
+// See ExportLayout for the meaning of allPlatforms.
+func (o *OrasRemote) ExportTarball(ctx context.Context, tarballPath string, allPlatforms bool) (ocispec.Descriptor, error) {
+	tmp, err := os.MkdirTemp("", "oci-layout-")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	root, err := o.ExportLayout(ctx, tmp, allPlatforms)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if err := helpers.CreateReproducibleTarballFromDir(tmp, "", tarballPath); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return root, nil
+}
+
+// ImportLayout pushes the artifact in the OCI image layout directory or tarball at path to the remote and returns its root descriptor.
+//
+// The artifact tagged with the remote's reference is imported, or the only tagged artifact if the layout has just one.
+// Image indexes are pushed as-is under the remote's tag, while a single manifest is merged into the remote's index
+// using its platform from the layout, or the target platform if it has none.
+func (o *OrasRemote) ImportLayout(ctx context.Context, path string) (ocispec.Descriptor, error) {
+	var store *oci.ReadOnlyStore
+	var err error
+	if helpers.IsDir(path) {
+		store, err = oci.NewFromFS(ctx, os.DirFS(path))
+	} else {
+		store, err = oci.NewFromTar(ctx, path)
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to open OCI image layout %s: %w", path, err)
+	}
+
+	root, err := resolveLayoutRoot(ctx, store, o.repo.Reference.Reference)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	// the existence checks of the copy decide what is pushed, so they are never served by mirrors
+	ctx = withoutMirrors(ctx)
+	copyOpts := o.GetDefaultCopyOpts()
+	copyOpts.Concurrency = o.concurrency
+	if err := oras.CopyGraph(ctx, store, o.repo, root, copyOpts.CopyGraphOptions); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	tag := o.repo.Reference.Reference
+	if root.MediaType == ocispec.MediaTypeImageIndex {
+		if err := o.repo.Tag(ctx, root, tag); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		o.root = nil
+		return root, nil
+	}
+
+	platform := root.Platform
+	if platform == nil {
+		platform = o.targetPlatform
+	}
+	published := ocispec.Descriptor{
+		MediaType: root.MediaType,
+		Digest:    root.Digest,
+		Size:      root.Size,
+	}
+	if err := o.updateIndex(ctx, tag, published, platform); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return root, nil
+}
+
+// resolveExportRoot returns the descriptor of the artifact to export.
+func (o *OrasRemote) resolveExportRoot(ctx context.Context, allPlatforms bool) (ocispec.Descriptor, error) {
+	if allPlatforms {
+		return o.repo.Resolve(ctx, o.repo.Reference.Reference)
+	}
+	root, err := o.ResolveRoot(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	// record the platform so it can be restored on import
+	if root.Platform == nil {
+		root.Platform = o.targetPlatform
+	}
+	return root, nil
+}
+
+// resolveLayoutRoot resolves reference in the layout, falling back to the only tagged artifact.
+func resolveLayoutRoot(ctx context.Context, store *oci.ReadOnlyStore, reference string) (ocispec.Descriptor, error) {
+	root, err := store.Resolve(ctx, reference)
+	if err == nil {
+		return root, nil
+	}
+	if !errors.Is(err, errdef.ErrNotFound) {
+		return ocispec.Descriptor{}, err
+	}
+
+	var tags []string
+	if err := store.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(tags) != 1 {
+		return ocispec.Descriptor{}, fmt.Errorf("%q not found in OCI image layout with tags %v: %w", reference, tags, errdef.ErrNotFound)
+	}
+	return store.Resolve(ctx, tags[0])
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+)
+
+func (suite *OCISuite) publishLayoutPackage(ctx context.Context, registryURL string, arches ...string) {
+	suite.T().Helper()
+	for _, arch := range arches {
+		remote, desc := suite.pushTestManifest(registryURL, PlatformForArch(arch), arch+"-file")
+		suite.NoError(remote.UpdateIndex(ctx, "0.0.1", desc))
+	}
+}
+
+func (suite *OCISuite) TestExportImportLayout() {
+	ctx := context.TODO()
+	srcRegistryURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	suite.publishLayoutPackage(ctx, srcRegistryURL, "amd64", "arm64")
+
+	src, err := NewOrasRemote(srcRegistryURL, PlatformForArch("arm64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	layoutDir := suite.T().TempDir()
+	exported, err := src.ExportLayout(ctx, layoutDir, false)
+	suite.NoError(err)
+	suite.Equal(ocispec.MediaTypeImageManifest, exported.MediaType)
+	suite.FileExists(filepath.Join(layoutDir, ocispec.ImageIndexFile))
+	suite.FileExists(filepath.Join(layoutDir, ocispec.ImageLayoutFile))
+	suite.FileExists(filepath.Join(layoutDir, ocispec.ImageBlobsDir, "sha256", exported.Digest.Encoded()))
+	suite.NoDirExists(filepath.Join(layoutDir, "ingest"))
+
+	// a single manifest is merged into the destination index under its exported platform
+	dstRegistryURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	dst, err := NewOrasRemote(dstRegistryURL, PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	imported, err := dst.ImportLayout(ctx, layoutDir)
+	suite.NoError(err)
+	suite.Equal(exported.Digest, imported.Digest)
+
+	dstArm, err := NewOrasRemote(dstRegistryURL, PlatformForArch("arm64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	root, err := dstArm.FetchRoot(ctx)
+	suite.NoError(err)
+	b, err := dstArm.FetchLayer(ctx, root.Locate("test-file"))
+	suite.NoError(err)
+	suite.Equal("arm64-file", string(b))
+}
+
+func (suite *OCISuite) TestExportImportTarball() {
+	ctx := context.TODO()
+	srcRegistryURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	suite.publishLayoutPackage(ctx, srcRegistryURL, "amd64", "arm64")
+
+	src, err := NewOrasRemote(srcRegistryURL, PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	tarballPath := filepath.Join(suite.T().TempDir(), "package.tar")
+	exported, err := src.ExportTarball(ctx, tarballPath, true)
+	suite.NoError(err)
+	suite.Equal(ocispec.MediaTypeImageIndex, exported.MediaType)
+
+	// the layout's only tag is 0.0.1, so it is found by that tag and pushed under the remote's 1.0.1 tag
+	dstRegistryURL := suite.setupInMemoryRegistry(ctx)
+	dst, err := NewOrasRemote(dstRegistryURL, PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	imported, err := dst.ImportLayout(ctx, tarballPath)
+	suite.NoError(err)
+	suite.Equal(exported.Digest, imported.Digest)
+	resolved, err := dst.Repo().Resolve(ctx, "1.0.1")
+	suite.NoError(err)
+	suite.Equal(exported.Digest, resolved.Digest)
+	_, err = dst.Repo().Resolve(ctx, "0.0.1")
+	suite.ErrorIs(err, errdef.ErrNotFound)
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		remote, err := NewOrasRemote(dstRegistryURL, PlatformForArch(arch), WithPlainHTTP(true))
+		suite.NoError(err)
+		root, err := remote.FetchRoot(ctx)
+		suite.NoError(err)
+		b, err := remote.FetchLayer(ctx, root.Locate("test-file"))
+		suite.NoError(err)
+		suite.Equal(arch+"-file", string(b))
+	}
+
+	// a layout with several tags can't be imported by a reference it does not contain
+	suite.NoError(src.Repo().Tag(ctx, exported, "0.0.2"))
+	layoutDir := suite.T().TempDir()
+	for _, tag := range []string{"0.0.1", "0.0.2"} {
+		remote, err := NewOrasRemote(strings.Replace(srcRegistryURL, ":0.0.1", ":"+tag, 1), PlatformForArch("amd64"), WithPlainHTTP(true))
+		suite.NoError(err)
+		_, err = remote.ExportLayout(ctx, layoutDir, true)
+		suite.NoError(err)
+	}
+	missing, err := NewOrasRemote(dstRegistryURL, PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	_, err = missing.ImportLayout(ctx, layoutDir)
+	suite.ErrorIs(err, errdef.ErrNotFound)
+}
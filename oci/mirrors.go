@@ -0,0 +1,318 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+// rewrite replaces the prefix of a `registry/repository` reference.
+type rewrite struct {
+	prefix      string
+	replacement string
+}
+
+// defaultRewrites are applied after any rewrites given with WithRewrite.
+var defaultRewrites = []rewrite{
+	// this allows end users to use docker.io as an alias for registry-1.docker.io
+	{prefix: "docker.io", replacement: "registry-1.docker.io"},
+	{prefix: "🦄", replacement: "ghcr.io/defenseunicorns/packages"},
+	{prefix: "defenseunicorns", replacement: "ghcr.io/defenseunicorns/packages"},
+}
+
+// Mirror is an alternative endpoint that serves pulls for a registry.
+type Mirror struct {
+	// Endpoint is the host of the mirror, optionally followed by a path that is prepended to mirrored repositories
+	Endpoint string
+	// PlainHTTP connects to the mirror over HTTP instead of HTTPS
+	PlainHTTP bool
+	// InsecureSkipVerify skips TLS verification of the mirror's certificate
+	InsecureSkipVerify bool
+	// CAFile is a PEM file of certificate authorities trusted for the mirror, in addition to those of the system
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key presented to the mirror
+	CertFile string
+	KeyFile  string
+}
+
+// WithRewrite rewrites references that start with the `registry/repository` prefix to start with replacement instead.
+//
+// The longest matching prefix wins, rewrites given here take precedence over the defaults
+// (docker.io to registry-1.docker.io, and 🦄 or defenseunicorns to ghcr.io/defenseunicorns/packages).
+func WithRewrite(prefix, replacement string) Modifier {
+	return func(o *OrasRemote) {
+		o.rewrites = append(o.rewrites, rewrite{
+			prefix:      strings.TrimSuffix(prefix, "/"),
+			replacement: strings.TrimSuffix(replacement, "/"),
+		})
+	}
+}
+
+// WithMirrors serves pulls for references that start with the `registry/repository` prefix from the given mirrors.
+//
+// Mirrors are tried in order for every pull and resolve, falling back to the next mirror and finally the registry
+// itself when a mirror can't be reached or does not have the content. Writes always go to the registry, along with
+// the reads they depend on, such as the existence checks before a push and the index reads of UpdateIndex.
+// The prefix is rewritten the same way as references, so docker.io matches registry-1.docker.io.
+func WithMirrors(prefix string, mirrors ...Mirror) Modifier {
+	return func(o *OrasRemote) {
+		if o.mirrors == nil {
+			o.mirrors = map[string][]Mirror{}
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		o.mirrors[prefix] = append(o.mirrors[prefix], mirrors...)
+	}
+}
+
+// rewriteReference applies the longest matching rewrite to the `registry/repository` of ref.
+func (o *OrasRemote) rewriteReference(ref registry.Reference) registry.Reference {
+	name := o.rewriteName(ref.Registry + "/" + ref.Repository)
+	ref.Registry, ref.Repository, _ = strings.Cut(name, "/")
+	return ref
+}
+
+// rewriteName applies the longest matching rewrite to a `registry/repository` name.
+func (o *OrasRemote) rewriteName(name string) string {
+	var match rewrite
+	for _, rw := range slices.Concat(o.rewrites, defaultRewrites) {
+		if len(rw.prefix) > len(match.prefix) && hasPathPrefix(name, rw.prefix) {
+			match = rw
+		}
+	}
+	if match.prefix == "" {
+		return name
+	}
+	return match.replacement + strings.TrimPrefix(name, match.prefix)
+}
+
+// matchMirrors returns the mirrors for the longest prefix matching the reference.
+func (o *OrasRemote) matchMirrors(ref registry.Reference) []Mirror {
+	name := ref.Registry + "/" + ref.Repository
+	var match string
+	var mirrors []Mirror
+	for prefix, m := range o.mirrors {
+		rewritten := o.rewriteName(prefix)
+		if len(rewritten) > len(match) && hasPathPrefix(name, rewritten) {
+			match = rewritten
+			mirrors = m
+		}
+	}
+	return mirrors
+}
+
+// hasPathPrefix returns true if name equals prefix or starts with prefix followed by a path separator.
+func hasPathPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// mirrorEndpoint is a mirror with its own authenticated client.
+type mirrorEndpoint struct {
+	Mirror
+	host       string
+	path       string
+	client     *auth.Client
+	transport  *helpers.Transport
+	repository string
+}
+
+var _ remote.Client = (*mirrorClient)(nil)
+
+// mirrorClient sends read requests for a registry to its mirrors before the registry itself.
+type mirrorClient struct {
+	upstream *auth.Client
+	host     string
+	mirrors  []*mirrorEndpoint
+	log      *slog.Logger
+}
+
+// setMirrors wraps the repository client so reads are served by the mirrors configured for ref.
+func (o *OrasRemote) setMirrors(ref registry.Reference, upstream *auth.Client) error {
+	mirrors := o.matchMirrors(ref)
+	if len(mirrors) == 0 {
+		o.repo.Client = upstream
+		return nil
+	}
+
+	base, ok := o.progTransport.Base.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unable to configure mirrors, base transport is not an http.Transport")
+	}
+
+	mc := &mirrorClient{
+		upstream: upstream,
+		host:     ref.Host(),
+		log:      o.log,
+	}
+	for _, mirror := range mirrors {
+		host, path, _ := strings.Cut(strings.TrimSuffix(mirror.Endpoint, "/"), "/")
+		transport := base.Clone()
+		if err := applyMirrorTLS(transport, mirror); err != nil {
+			return err
+		}
+		progTransport := helpers.NewTransport(transport, o.progTransport.ProgressBar)
+		repository := ref.Repository
+		if path != "" {
+			repository = path + "/" + ref.Repository
+		}
+		mc.mirrors = append(mc.mirrors, &mirrorEndpoint{
+			Mirror:     mirror,
+			host:       host,
+			path:       path,
+			transport:  progTransport,
+			repository: repository,
+			client: &auth.Client{
+				Client:     &http.Client{Transport: progTransport},
+				Header:     upstream.Header.Clone(),
+				Credential: upstream.Credential,
+				Cache:      auth.NewCache(),
+			},
+		})
+	}
+	o.repo.Client = mc
+	return nil
+}
+
+// applyMirrorTLS applies the TLS settings of mirror to transport.
+func applyMirrorTLS(transport *http.Transport, mirror Mirror) error {
+	if mirror.InsecureSkipVerify {
+		applyInsecureSkipVerify(transport, true)
+	}
+	if mirror.CAFile == "" && mirror.CertFile == "" && mirror.KeyFile == "" {
+		return nil
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	if mirror.CAFile != "" {
+		b, err := os.ReadFile(mirror.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file of mirror %s: %w", mirror.Endpoint, err)
+		}
+		pool := transport.TLSClientConfig.RootCAs
+		if pool != nil {
+			pool = pool.Clone()
+		} else if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates found in CA file %s of mirror %s", mirror.CAFile, mirror.Endpoint)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if mirror.CertFile != "" || mirror.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(mirror.CertFile, mirror.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate of mirror %s: %w", mirror.Endpoint, err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// upstreamOnlyKey is the context key set by withoutMirrors.
+type upstreamOnlyKey struct{}
+
+// withoutMirrors returns a context whose requests are never served by mirrors, for reads that a write depends on.
+//
+// A mirror may have content the registry does not, or an outdated index, so an existence check against a mirror would
+// skip uploading a blob, and an index read from a mirror would be merged and pushed over the registry's index.
+func withoutMirrors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, upstreamOnlyKey{}, true)
+}
+
+// Do sends GET and HEAD requests for the registry to each mirror in turn, then to the registry itself.
+//
+// Any other request, or a request with a context from withoutMirrors, is only sent to the registry.
+func (c *mirrorClient) Do(req *http.Request) (*http.Response, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.URL.Host != c.host || !strings.HasPrefix(req.URL.Path, "/v2/") {
+		return c.upstream.Do(req)
+	}
+	if req.Context().Value(upstreamOnlyKey{}) != nil {
+		return c.upstream.Do(req)
+	}
+
+	for _, mirror := range c.mirrors {
+		resp, err := mirror.do(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			c.logServed(req, mirror.Endpoint)
+			return resp, nil
+		}
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctxErr
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			resp.Body.Close()
+		}
+		if c.log != nil {
+			c.log.Debug("registry mirror failed, trying next endpoint", "mirror", mirror.Endpoint, "method", req.Method, "path", req.URL.Path, "error", err.Error())
+		}
+	}
+
+	resp, err := c.upstream.Do(req)
+	if err == nil {
+		c.logServed(req, c.host)
+	}
+	return resp, err
+}
+
+// logServed logs which endpoint served the request.
+func (c *mirrorClient) logServed(req *http.Request, endpoint string) {
+	if c.log != nil {
+		c.log.Debug("registry request served", "endpoint", endpoint, "method", req.Method, "path", req.URL.Path)
+	}
+}
+
+// do sends the request to the mirror, prefixing the repository with the mirror path.
+func (m *mirrorEndpoint) do(req *http.Request) (*http.Response, error) {
+	ctx := auth.AppendRepositoryScope(req.Context(), registry.Reference{Registry: m.host, Repository: m.repository}, auth.ActionPull)
+	mirrorReq := req.Clone(ctx)
+	mirrorReq.Host = m.host
+	mirrorReq.URL.Host = m.host
+	mirrorReq.URL.Scheme = "https"
+	if m.PlainHTTP {
+		mirrorReq.URL.Scheme = "http"
+	}
+	if m.path != "" {
+		mirrorReq.URL.Path = "/v2/" + m.path + strings.TrimPrefix(req.URL.Path, "/v2")
+		mirrorReq.URL.RawPath = ""
+	}
+	return m.client.Do(mirrorReq)
+}
+
+// authClient returns the auth client of the repository, unwrapping any mirror client.
+func (o *OrasRemote) authClient() (*auth.Client, bool) {
+	switch client := o.repo.Client.(type) {
+	case *auth.Client:
+		return client, true
+	case *mirrorClient:
+		return client.upstream, true
+	default:
+		return nil, false
+	}
+}
+
+// setProgressWriter sets the progress writer on the transports of every mirror.
+func (c *mirrorClient) setProgressWriter(bar helpers.ProgressWriter) {
+	for _, mirror := range c.mirrors {
+		mirror.transport.ProgressBar = bar
+	}
+}
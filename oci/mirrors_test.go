@@ -0,0 +1,227 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"log/slog"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/content/file"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func TestRewriteReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		mods     []Modifier
+		expected string
+	}{
+		{
+			name:     "docker.io",
+			url:      "docker.io/library/alpine:latest",
+			expected: "registry-1.docker.io/library/alpine:latest",
+		},
+		{
+			name:     "unicorn",
+			url:      "🦄/init:v0.1.0",
+			expected: "ghcr.io/defenseunicorns/packages/init:v0.1.0",
+		},
+		{
+			name:     "defenseunicorns",
+			url:      "defenseunicorns/init:v0.1.0",
+			expected: "ghcr.io/defenseunicorns/packages/init:v0.1.0",
+		},
+		{
+			name:     "no match",
+			url:      "ghcr.io/defenseunicorns/init:v0.1.0",
+			expected: "ghcr.io/defenseunicorns/init:v0.1.0",
+		},
+		{
+			name:     "prefix matches whole path components",
+			url:      "example.com/teamsters/app:v1",
+			mods:     []Modifier{WithRewrite("example.com/team", "harbor.local/team")},
+			expected: "example.com/teamsters/app:v1",
+		},
+		{
+			name:     "custom rewrite",
+			url:      "example.com/team/app:v1",
+			mods:     []Modifier{WithRewrite("example.com/team/", "harbor.local/proxy/team")},
+			expected: "harbor.local/proxy/team/app:v1",
+		},
+		{
+			name: "longest prefix wins",
+			url:  "example.com/team/app:v1",
+			mods: []Modifier{
+				WithRewrite("example.com", "harbor.local/example"),
+				WithRewrite("example.com/team", "harbor.local/team"),
+			},
+			expected: "harbor.local/team/app:v1",
+		},
+		{
+			name:     "custom rewrite overrides default",
+			url:      "docker.io/library/alpine:latest",
+			mods:     []Modifier{WithRewrite("docker.io", "harbor.local/dockerhub")},
+			expected: "harbor.local/dockerhub/library/alpine:latest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+			remote, err := NewOrasRemote(tt.url, PlatformForArch(testArch), tt.mods...)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, remote.Repo().Reference.String())
+		})
+	}
+}
+
+func TestMatchMirrors(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	local := Mirror{Endpoint: "harbor.local/dockerhub"}
+	team := Mirror{Endpoint: "harbor.local/team"}
+	remote, err := NewOrasRemote("docker.io/team/app:v1", PlatformForArch(testArch),
+		WithMirrors("docker.io", local), WithMirrors("registry-1.docker.io/team", team))
+	require.NoError(t, err)
+	require.Equal(t, []Mirror{team}, remote.matchMirrors(remote.Repo().Reference))
+
+	other, err := NewOrasRemote("docker.io/library/alpine:latest", PlatformForArch(testArch), WithMirrors("docker.io", local))
+	require.NoError(t, err)
+	require.Equal(t, []Mirror{local}, other.matchMirrors(other.Repo().Reference))
+	client, ok := other.repo.Client.(*mirrorClient)
+	require.True(t, ok)
+	require.Equal(t, "harbor.local", client.mirrors[0].host)
+	require.Equal(t, "dockerhub/library/alpine", client.mirrors[0].repository)
+
+	_, ok = remote.authClient()
+	require.True(t, ok)
+}
+
+func (suite *OCISuite) TestMirrors() {
+	ctx := context.TODO()
+	upstreamURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	upstreamHost := strings.Split(strings.TrimPrefix(upstreamURL, helpers.OCIURLPrefix), "/")[0]
+	mirrorURL := suite.setupInMemoryRegistry(ctx)
+	mirrorHost := strings.Split(strings.TrimPrefix(mirrorURL, helpers.OCIURLPrefix), "/")[0]
+
+	// the mirror holds the package under a path prefix
+	srcTempDir := suite.T().TempDir()
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	path := filepath.Join(srcTempDir, "mirrored-file")
+	suite.NoError(os.WriteFile(path, []byte("mirrored"), helpers.ReadWriteUser))
+	desc, err := src.Add(ctx, "mirrored-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	mirrored, err := NewOrasRemote(mirrorHost+"/cache/package:0.0.1", PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.publishPackageTo(mirrored, src, []ocispec.Descriptor{desc})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	mirrors := []Mirror{
+		{Endpoint: "localhost:1", PlainHTTP: true},
+		{Endpoint: mirrorHost + "/cache", PlainHTTP: true},
+	}
+	remote, err := NewOrasRemote(upstreamURL, PlatformForArch(testArch), WithPlainHTTP(true), WithLogger(logger),
+		WithMirrors(upstreamHost, mirrors...))
+	suite.NoError(err)
+	root, err := remote.FetchRoot(ctx)
+	suite.NoError(err)
+	b, err := remote.FetchLayer(ctx, root.Locate("mirrored-file"))
+	suite.NoError(err)
+	suite.Equal("mirrored", string(b))
+	suite.Contains(logs.String(), `"mirror":"localhost:1"`)
+	suite.Contains(logs.String(), `"endpoint":"`+mirrorHost+`/cache"`)
+	suite.NotContains(logs.String(), `"endpoint":"`+upstreamHost+`"`)
+
+	// content missing from every mirror is pulled from the registry itself
+	logs.Reset()
+	upstreamTempDir := suite.T().TempDir()
+	upstreamSrc, err := file.New(upstreamTempDir)
+	suite.NoError(err)
+	path = filepath.Join(upstreamTempDir, "upstream-file")
+	suite.NoError(os.WriteFile(path, []byte("upstream"), helpers.ReadWriteUser))
+	desc, err = upstreamSrc.Add(ctx, "upstream-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	upstream, err := NewOrasRemote(upstreamURL, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.publishPackageTo(upstream, upstreamSrc, []ocispec.Descriptor{desc})
+	remote, err = NewOrasRemote(upstreamURL, PlatformForArch(testArch), WithPlainHTTP(true), WithLogger(logger),
+		WithMirrors(upstreamHost, Mirror{Endpoint: mirrorHost, PlainHTTP: true}))
+	suite.NoError(err)
+	root, err = remote.FetchRoot(ctx)
+	suite.NoError(err)
+	b, err = remote.FetchLayer(ctx, root.Locate("upstream-file"))
+	suite.NoError(err)
+	suite.Equal("upstream", string(b))
+	suite.Contains(logs.String(), `"endpoint":"`+upstreamHost+`"`)
+
+	// writes, and the reads they depend on, always go to the registry even though the mirror has the layer and an index
+	upstreamRoot, err := upstream.ResolveRoot(ctx)
+	suite.NoError(err)
+	pushDir := suite.T().TempDir()
+	suite.NoError(os.WriteFile(filepath.Join(pushDir, "mirrored-file"), []byte("mirrored"), helpers.ReadWriteUser))
+	other, err := NewOrasRemote(upstreamURL, PlatformForArch("other-arch"), WithPlainHTTP(true),
+		WithMirrors(upstreamHost, Mirror{Endpoint: mirrorHost + "/cache", PlainHTTP: true}))
+	suite.NoError(err)
+	_, err = other.PushDirectory(ctx, "0.0.1", pushDir)
+	suite.NoError(err)
+	exists, err := upstream.Repo().Exists(ctx, ocispec.Descriptor{Digest: digest.FromString("mirrored"), Size: int64(len("mirrored"))})
+	suite.NoError(err)
+	suite.True(exists)
+	index, _, err := upstream.fetchIndex(ctx, "0.0.1")
+	suite.NoError(err)
+	suite.Len(index.Manifests, 2)
+	suite.Equal(upstreamRoot.Digest, index.Manifests[0].Digest)
+}
+
+func (suite *OCISuite) TestMirrorTLS() {
+	ctx := context.TODO()
+	upstreamURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	upstreamHost := strings.Split(strings.TrimPrefix(upstreamURL, helpers.OCIURLPrefix), "/")[0]
+	mirrorURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	mirrorHost := strings.Split(strings.TrimPrefix(mirrorURL, helpers.OCIURLPrefix), "/")[0]
+	publisher, desc := suite.pushTestManifest(mirrorURL, PlatformForArch(testArch), "mirrored")
+	suite.NoError(publisher.UpdateIndex(ctx, "0.0.1", desc))
+
+	// the mirror is served over TLS with a certificate from a CA the system does not trust
+	target, err := url.Parse("http://" + mirrorHost)
+	suite.NoError(err)
+	server := httptest.NewTLSServer(httputil.NewSingleHostReverseProxy(target))
+	defer server.Close()
+	tlsHost := strings.TrimPrefix(server.URL, "https://")
+	caPath := filepath.Join(suite.T().TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	suite.NoError(os.WriteFile(caPath, ca, helpers.ReadWriteUser))
+
+	remote, err := NewOrasRemote(upstreamURL, PlatformForArch(testArch), WithPlainHTTP(true),
+		WithMirrors(upstreamHost, Mirror{Endpoint: tlsHost, CAFile: caPath}))
+	suite.NoError(err)
+	root, err := remote.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(desc.Digest, root.Digest)
+
+	// without the CA the mirror is skipped, and the registry does not have the package
+	remote, err = NewOrasRemote(upstreamURL, PlatformForArch(testArch), WithPlainHTTP(true),
+		WithMirrors(upstreamHost, Mirror{Endpoint: tlsHost}))
+	suite.NoError(err)
+	_, err = remote.ResolveRoot(ctx)
+	suite.Error(err)
+
+	_, err = NewOrasRemote(upstreamURL, PlatformForArch(testArch),
+		WithMirrors(upstreamHost, Mirror{Endpoint: tlsHost, CertFile: caPath, KeyFile: caPath}))
+	suite.ErrorContains(err, "failed to load client certificate")
+}
@@ -4,12 +4,16 @@
 package oci
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +25,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
 	"oras.land/oras-go/v2/content/file"
 
 	"github.com/defenseunicorns/pkg/helpers/v2"
@@ -62,7 +67,10 @@ func (suite *OCISuite) setupInMemoryRegistry(ctx context.Context) string {
 	config.HTTP.Secret = "Fake secret so we don't get warning"
 	config.Log.AccessLog.Disabled = true
 	config.HTTP.DrainTimeout = 10 * time.Second
-	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]any{}}
+	config.Storage = map[string]configuration.Parameters{
+		"inmemory": map[string]any{},
+		"delete":   map[string]any{"enabled": true},
+	}
 
 	reg, err := registry.NewRegistry(ctx, config)
 	suite.NoError(err)
@@ -73,6 +81,16 @@ func (suite *OCISuite) setupInMemoryRegistry(ctx context.Context) string {
 
 	url := fmt.Sprintf("localhost:%d", port)
 
+	// wait for the registry to start accepting connections
+	suite.Eventually(func() bool {
+		resp, err := http.Get(fmt.Sprintf("http://%s/v2/", url))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 10*time.Second, 10*time.Millisecond)
+
 	return fmt.Sprintf("oci://%s/package:1.0.1", url)
 }
 
@@ -86,6 +104,11 @@ func (suite *OCISuite) TestPublishFailNoTitle() {
 }
 
 func (suite *OCISuite) publishPackage(src *file.Store, descs []ocispec.Descriptor) {
+	suite.T().Helper()
+	suite.publishPackageTo(suite.remote, src, descs)
+}
+
+func (suite *OCISuite) publishPackageTo(remote *OrasRemote, src *file.Store, descs []ocispec.Descriptor) {
 	suite.T().Helper()
 	ctx := context.TODO()
 	annotations := map[string]string{
@@ -93,16 +116,40 @@ func (suite *OCISuite) publishPackage(src *file.Store, descs []ocispec.Descripto
 		ocispec.AnnotationDescription: "description",
 	}
 
-	manifestConfigDesc, err := suite.remote.CreateAndPushManifestConfig(ctx, annotations, ocispec.MediaTypeLayoutHeader)
+	manifestConfigDesc, err := remote.CreateAndPushManifestConfig(ctx, annotations, ocispec.MediaTypeLayoutHeader)
 	suite.NoError(err)
 
-	manifestDesc, err := suite.remote.PackAndTagManifest(ctx, src, descs, manifestConfigDesc, annotations)
+	manifestDesc, err := remote.PackAndTagManifest(ctx, src, descs, manifestConfigDesc, annotations)
+	suite.NoError(err)
+	publishedDesc, err := oras.Copy(ctx, src, manifestDesc.Digest.String(), remote.Repo(), "", remote.GetDefaultCopyOpts())
 	suite.NoError(err)
-	publishedDesc, err := oras.Copy(ctx, src, manifestDesc.Digest.String(), suite.remote.Repo(), "", suite.remote.GetDefaultCopyOpts())
+
+	err = remote.UpdateIndex(ctx, "0.0.1", publishedDesc)
 	suite.NoError(err)
+}
 
-	err = suite.remote.UpdateIndex(ctx, "0.0.1", publishedDesc)
+// pushTestManifest pushes a manifest with a single file containing name to url for platform, without updating
+// any index, and returns the remote used, created with modifiers, and the published descriptor.
+func (suite *OCISuite) pushTestManifest(url string, platform ocispec.Platform, name string, modifiers ...Modifier) (*OrasRemote, ocispec.Descriptor) {
+	suite.T().Helper()
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+	path := filepath.Join(srcTempDir, "test-file")
+	suite.NoError(os.WriteFile(path, []byte(name), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
 	suite.NoError(err)
+	desc, err := src.Add(ctx, "test-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	remote, err := NewOrasRemote(url, platform, append([]Modifier{WithPlainHTTP(true)}, modifiers...)...)
+	suite.NoError(err)
+	annotations := map[string]string{ocispec.AnnotationTitle: name}
+	configDesc, err := remote.CreateAndPushManifestConfig(ctx, annotations, ocispec.MediaTypeLayoutHeader)
+	suite.NoError(err)
+	manifestDesc, err := remote.PackAndTagManifest(ctx, src, []ocispec.Descriptor{desc}, configDesc, annotations)
+	suite.NoError(err)
+	publishedDesc, err := oras.Copy(ctx, src, manifestDesc.Digest.String(), remote.Repo(), "", remote.GetDefaultCopyOpts())
+	suite.NoError(err)
+	return remote, publishedDesc
 }
 
 func (suite *OCISuite) TestCopyToTarget() {
@@ -172,6 +219,84 @@ func (suite *OCISuite) TestPulledPaths() {
 	}
 }
 
+func (suite *OCISuite) TestPullPathsConcurrent() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+
+	var files []string
+	var descs []ocispec.Descriptor
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	for i := range 8 {
+		name := fmt.Sprintf("parallel-file-%d", i)
+		path := filepath.Join(srcTempDir, name)
+		suite.NoError(os.WriteFile(path, []byte(strings.Repeat(name, 512)), helpers.ReadWriteUser))
+		desc, err := src.Add(ctx, name, ocispec.MediaTypeImageLayer, path)
+		suite.NoError(err)
+		descs = append(descs, desc)
+		files = append(files, name)
+	}
+	suite.publishPackage(src, descs)
+
+	remote, err := NewOrasRemote("oci://"+suite.remote.Repo().Reference.String(), PlatformForArch(testArch), WithPlainHTTP(true), WithConcurrency(4))
+	suite.NoError(err)
+	dstTempDir := suite.T().TempDir()
+	// the same layer by title and by digest is only pulled once
+	pulled, err := remote.PullPaths(ctx, dstTempDir, append(files, descs[0].Digest.Encoded()))
+	suite.NoError(err)
+	suite.Len(pulled, len(files)+1)
+	for i, file := range files {
+		suite.True(remote.FileDescriptorExists(descs[i], dstTempDir))
+		suite.NoFileExists(filepath.Join(dstTempDir, file+partialSuffix))
+	}
+}
+
+func (suite *OCISuite) TestPullPathResume() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+
+	fileName := "resumable-file"
+	fileContents := strings.Repeat("resume me please ", 4096)
+	path := filepath.Join(srcTempDir, fileName)
+	suite.NoError(os.WriteFile(path, []byte(fileContents), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	desc, err := src.Add(ctx, fileName, ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	remote, err := NewOrasRemote("oci://"+suite.remote.Repo().Reference.String(), PlatformForArch(testArch), WithPlainHTTP(true), WithLogger(logger))
+	suite.NoError(err)
+
+	dstTempDir := suite.T().TempDir()
+	dstPath := filepath.Join(dstTempDir, fileName)
+
+	// an interrupted download resumes from the end of the partial file
+	suite.NoError(os.WriteFile(dstPath+partialSuffix, []byte(fileContents[:len(fileContents)/2]), helpers.ReadWriteUser))
+	err = remote.PullPath(ctx, dstTempDir, desc)
+	suite.NoError(err)
+	b, err := os.ReadFile(dstPath)
+	suite.NoError(err)
+	suite.Equal(fileContents, string(b))
+	suite.NoFileExists(dstPath + partialSuffix)
+	suite.Contains(logs.String(), "resuming layer download")
+
+	// corrupt partial content fails verification and is discarded
+	suite.NoError(os.Remove(dstPath))
+	suite.NoError(os.WriteFile(dstPath+partialSuffix, []byte(strings.Repeat("x", 100)), helpers.ReadWriteUser))
+	err = remote.PullPath(ctx, dstTempDir, desc)
+	suite.ErrorIs(err, content.ErrMismatchedDigest)
+	suite.NoFileExists(dstPath)
+
+	err = remote.PullPath(ctx, dstTempDir, desc)
+	suite.NoError(err)
+	b, err = os.ReadFile(dstPath)
+	suite.NoError(err)
+	suite.Equal(fileContents, string(b))
+}
+
 func (suite *OCISuite) TestResolveRoot() {
 	suite.T().Log("Testing resolve root")
 	ctx := context.TODO()
@@ -267,6 +392,263 @@ func (suite *OCISuite) TestCopy() {
 	suite.Equal(int(totalSize), testWriter.bytesSent)
 }
 
+func (suite *OCISuite) TestCopyConcurrent() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+
+	var descs []ocispec.Descriptor
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	for i := range 8 {
+		name := fmt.Sprintf("concurrent-file-%d", i)
+		path := filepath.Join(srcTempDir, name)
+		err := os.WriteFile(path, []byte(strings.Repeat(name, 1024)), helpers.ReadWriteUser)
+		suite.NoError(err)
+		desc, err := src.Add(ctx, name, ocispec.MediaTypeImageLayer, path)
+		suite.NoError(err)
+		descs = append(descs, desc)
+	}
+
+	suite.publishPackage(src, descs)
+
+	dstRegistryURL := suite.setupInMemoryRegistry(ctx)
+	dstRemote, err := NewOrasRemote(dstRegistryURL, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	testWriter := &TestProgressWriter{}
+	err = Copy(ctx, suite.remote, dstRemote, nil, 4, testWriter)
+	suite.NoError(err)
+
+	srcRoot, err := suite.remote.FetchRoot(ctx)
+	suite.NoError(err)
+	totalSize := srcRoot.Config.Size
+	for _, layer := range srcRoot.Layers {
+		totalSize += layer.Size
+		ok, err := dstRemote.Repo().Exists(ctx, layer)
+		suite.NoError(err)
+		suite.True(ok)
+	}
+	suite.Equal(int(totalSize), testWriter.bytesSent)
+
+	// copying again should skip every layer but still report the full size
+	testWriter = &TestProgressWriter{}
+	err = Copy(ctx, suite.remote, dstRemote, nil, 4, testWriter)
+	suite.NoError(err)
+	suite.Equal(int(totalSize), testWriter.bytesSent)
+}
+
+func (suite *OCISuite) TestCopyManifest() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+	files := []string{"kept-file", "filtered-file"}
+
+	var descs []ocispec.Descriptor
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	for _, file := range files {
+		path := filepath.Join(srcTempDir, file)
+		suite.NoError(os.WriteFile(path, []byte(file), helpers.ReadWriteUser))
+		desc, err := src.Add(ctx, file, ocispec.MediaTypeImageLayer, path)
+		suite.NoError(err)
+		descs = append(descs, desc)
+	}
+	suite.publishPackage(src, descs)
+
+	srcRootDesc, err := suite.remote.ResolveRoot(ctx)
+	suite.NoError(err)
+	tag := suite.remote.Repo().Reference.Reference
+
+	// a full copy keeps the manifest digest
+	dstRegistryURL := suite.setupInMemoryRegistry(ctx)
+	dstRemote, err := NewOrasRemote(dstRegistryURL, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	err = Copy(ctx, suite.remote, dstRemote, nil, 2, nil, WithCopyManifest())
+	suite.NoError(err)
+
+	dstTagged, err := NewOrasRemote(strings.Replace(dstRegistryURL, ":1.0.1", ":"+tag, 1), PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	dstRootDesc, err := dstTagged.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(srcRootDesc.Digest, dstRootDesc.Digest)
+
+	// a filtered copy rewrites the manifest to only reference the copied layers
+	include := func(d ocispec.Descriptor) bool {
+		return d.Annotations[ocispec.AnnotationTitle] == "kept-file"
+	}
+	dstRegistryURL = suite.setupInMemoryRegistry(ctx)
+	dstRemote, err = NewOrasRemote(dstRegistryURL, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	err = Copy(ctx, suite.remote, dstRemote, include, 2, nil, WithCopyManifest())
+	suite.NoError(err)
+
+	dstTagged, err = NewOrasRemote(strings.Replace(dstRegistryURL, ":1.0.1", ":"+tag, 1), PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	dstRoot, err := dstTagged.FetchRoot(ctx)
+	suite.NoError(err)
+	suite.Len(dstRoot.Layers, 1)
+	suite.Equal("kept-file", dstRoot.Layers[0].Annotations[ocispec.AnnotationTitle])
+	b, err := dstTagged.FetchLayer(ctx, dstRoot.Layers[0])
+	suite.NoError(err)
+	suite.Equal("kept-file", string(b))
+}
+
+func (suite *OCISuite) TestCopyManifestTagMoved() {
+	ctx := context.TODO()
+	url := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	publisher, original := suite.pushTestManifest(url, PlatformForArch(testArch), "original")
+	suite.NoError(publisher.UpdateIndex(ctx, "0.0.1", original))
+
+	srcRemote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	_, err = srcRemote.FetchRoot(ctx)
+	suite.NoError(err)
+
+	// the tag moves after the root manifest was fetched
+	_, moved := suite.pushTestManifest(url, PlatformForArch(testArch), "moved")
+	suite.NoError(publisher.UpdateIndex(ctx, "0.0.1", moved))
+
+	dstURL := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	dstRemote, err := NewOrasRemote(dstURL, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	err = Copy(ctx, srcRemote, dstRemote, nil, 2, nil, WithCopyManifest())
+	suite.NoError(err)
+	dstRootDesc, err := dstRemote.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(original.Digest, dstRootDesc.Digest)
+}
+
+func (suite *OCISuite) TestCopyMount() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+
+	path := filepath.Join(srcTempDir, "mounted-file")
+	suite.NoError(os.WriteFile(path, []byte("mount me"), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	desc, err := src.Add(ctx, "mounted-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	srcRemote, err := NewOrasRemote("oci://"+suite.remote.Repo().Reference.String(), PlatformForArch(testArch), WithPlainHTTP(true), WithLogger(logger))
+	suite.NoError(err)
+
+	dstRef := suite.remote.Repo().Reference
+	dstRef.Repository = "promoted"
+	dstRemote, err := NewOrasRemote("oci://"+dstRef.String(), PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	testWriter := &TestProgressWriter{}
+	err = Copy(ctx, srcRemote, dstRemote, nil, 2, testWriter)
+	suite.NoError(err)
+
+	srcRoot, err := srcRemote.FetchRoot(ctx)
+	suite.NoError(err)
+	totalSize := srcRoot.Config.Size
+	for _, layer := range srcRoot.Layers {
+		totalSize += layer.Size
+		ok, err := dstRemote.Repo().Exists(ctx, layer)
+		suite.NoError(err)
+		suite.True(ok)
+	}
+	suite.Equal(int(totalSize), testWriter.bytesSent)
+	suite.Contains(logs.String(), "layer mounted from source repository")
+	suite.NotContains(logs.String(), "falling back to streaming")
+}
+
+func (suite *OCISuite) TestCopyIndex() {
+	ctx := context.TODO()
+	srcRegistryURL := suite.setupInMemoryRegistry(ctx)
+	arches := []string{"amd64", "arm64", "s390x"}
+	for _, arch := range arches {
+		srcTempDir := suite.T().TempDir()
+		src, err := file.New(srcTempDir)
+		suite.NoError(err)
+		var descs []ocispec.Descriptor
+		for _, name := range []string{"shared-file", arch + "-file"} {
+			path := filepath.Join(srcTempDir, name)
+			suite.NoError(os.WriteFile(path, []byte(name), helpers.ReadWriteUser))
+			desc, err := src.Add(ctx, name, ocispec.MediaTypeImageLayer, path)
+			suite.NoError(err)
+			descs = append(descs, desc)
+		}
+		remote, err := NewOrasRemote(srcRegistryURL, PlatformForArch(arch), WithPlainHTTP(true))
+		suite.NoError(err)
+		suite.publishPackageTo(remote, src, descs)
+	}
+
+	srcRemote, err := NewOrasRemote(strings.Replace(srcRegistryURL, ":1.0.1", ":0.0.1", 1), PlatformForArch("amd64"), WithPlainHTTP(true))
+	suite.NoError(err)
+	srcIndexDesc, err := srcRemote.Repo().Resolve(ctx, "0.0.1")
+	suite.NoError(err)
+
+	tests := []struct {
+		name      string
+		platforms []ocispec.Platform
+		expected  []string
+	}{
+		{
+			name:     "all platforms",
+			expected: arches,
+		},
+		{
+			name:      "subset of platforms",
+			platforms: []ocispec.Platform{PlatformForArch("amd64"), PlatformForArch("arm64")},
+			expected:  []string{"amd64", "arm64"},
+		},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			dstRegistryURL := suite.setupInMemoryRegistry(ctx)
+			dstRemote, err := NewOrasRemote(strings.Replace(dstRegistryURL, ":1.0.1", ":0.0.1", 1), PlatformForArch("amd64"), WithPlainHTTP(true))
+			suite.NoError(err)
+			err = Copy(ctx, srcRemote, dstRemote, nil, 3, nil, WithCopyIndex(tt.platforms...))
+			suite.NoError(err)
+
+			dstIndexDesc, err := dstRemote.Repo().Resolve(ctx, "0.0.1")
+			suite.NoError(err)
+			if tt.platforms == nil {
+				suite.Equal(srcIndexDesc.Digest, dstIndexDesc.Digest)
+			}
+			index, err := FetchUnmarshal[ocispec.Index](ctx, dstRemote.Repo(), json.Unmarshal, dstIndexDesc)
+			suite.NoError(err)
+			suite.Len(index.Manifests, len(tt.expected))
+
+			for _, arch := range tt.expected {
+				archRemote, err := NewOrasRemote(strings.Replace(dstRegistryURL, ":1.0.1", ":0.0.1", 1), PlatformForArch(arch), WithPlainHTTP(true))
+				suite.NoError(err)
+				root, err := archRemote.FetchRoot(ctx)
+				suite.NoError(err)
+				b, err := archRemote.FetchLayer(ctx, root.Locate(arch+"-file"))
+				suite.NoError(err)
+				suite.Equal(arch+"-file", string(b))
+			}
+		})
+	}
+
+	err = Copy(ctx, srcRemote, suite.remote, nil, 3, nil, WithCopyIndex(PlatformForArch("riscv64")))
+	suite.Error(err)
+}
+
+func (suite *OCISuite) TestCopyError() {
+	ctx := context.TODO()
+	srcTempDir := suite.T().TempDir()
+
+	path := filepath.Join(srcTempDir, "copy-error-file")
+	suite.NoError(os.WriteFile(path, []byte("cannot be copied"), helpers.ReadWriteUser))
+	src, err := file.New(srcTempDir)
+	suite.NoError(err)
+	desc, err := src.Add(ctx, "copy-error-file", ocispec.MediaTypeImageLayer, path)
+	suite.NoError(err)
+	suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+	port, err := freeport.GetFreePort()
+	suite.NoError(err)
+	dstRemote, err := NewOrasRemote(fmt.Sprintf("oci://localhost:%d/package:1.0.1", port), PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	err = Copy(ctx, suite.remote, dstRemote, nil, 2, nil)
+	suite.Error(err)
+}
+
 func TestRemoveDuplicateDescriptors(t *testing.T) {
 	tests := []struct {
 		name     string
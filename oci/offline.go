@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+// ErrNotCached is returned when content required in offline mode is not in the cache
+var ErrNotCached = errors.New("not found in the cache")
+
+// cacheMode controls when the cache is used instead of the registry.
+type cacheMode int
+
+const (
+	// cacheModeDefault reads blobs from the cache and resolves references against the registry
+	cacheModeDefault cacheMode = iota
+	// cacheModePreferCache resolves references from the cache, falling back to the registry
+	cacheModePreferCache
+	// cacheModeOffline never contacts the registry for reads
+	cacheModeOffline
+)
+
+// WithOffline serves all reads from the cache set with WithCache or WithCacheManager, the registry is never contacted.
+//
+// ResolveRoot uses the resolution recorded in the cache by the last online resolve of the reference and platform made
+// with WithPreferCache.
+// Anything missing from the cache fails with an error wrapping ErrNotCached.
+func WithOffline() Modifier {
+	return func(o *OrasRemote) {
+		o.cacheMode = cacheModeOffline
+	}
+}
+
+// WithPreferCache resolves references from the cache set with WithCache or WithCacheManager when they were resolved
+// before, falling back to the registry for anything missing from the cache.
+//
+// Resolutions made against the registry are recorded in the cache, along with their manifest, for later remotes
+// with WithPreferCache or WithOffline.
+func WithPreferCache() Modifier {
+	return func(o *OrasRemote) {
+		o.cacheMode = cacheModePreferCache
+	}
+}
+
+// resolutionKey returns the reference the resolution of the remote's reference and platform is tagged with in the cache.
+func (o *OrasRemote) resolutionKey() string {
+	key := o.repo.Reference.String()
+	if o.targetPlatform != nil {
+		key += "#" + platformString(*o.targetPlatform)
+	}
+	return key
+}
+
+// resolveCached resolves the remote's reference and platform from the cache.
+func (o *OrasRemote) resolveCached(ctx context.Context) (ocispec.Descriptor, error) {
+	if o.cache == nil {
+		return ocispec.Descriptor{}, errNoCache
+	}
+	desc, err := o.cache.Resolve(ctx, o.resolutionKey())
+	if err != nil {
+		if o.targetPlatform != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("resolution of %s for platform %s: %w", o.repo.Reference, platformString(*o.targetPlatform), ErrNotCached)
+		}
+		return ocispec.Descriptor{}, fmt.Errorf("resolution of %s: %w", o.repo.Reference, ErrNotCached)
+	}
+	return desc, nil
+}
+
+// recordResolution records the resolution of the remote's reference and platform in the cache, caching the manifest.
+func (o *OrasRemote) recordResolution(ctx context.Context, desc ocispec.Descriptor) {
+	if o.cache == nil {
+		return
+	}
+	err := func() error {
+		exists, err := o.cache.Exists(ctx, desc)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// fetching through the caching target stores the manifest in the cache
+			if _, err := content.FetchAll(ctx, o, desc); err != nil {
+				return err
+			}
+		}
+		return o.cache.Tag(ctx, desc, o.resolutionKey())
+	}()
+	if err != nil && o.log != nil {
+		o.log.Warn("unable to record resolution in the cache", "reference", o.resolutionKey(), "error", err.Error())
+	}
+}
+
+// offlineTarget is the source of the cache when offline, it resolves references recorded in the cache and has no
+// content of its own, so every blob missing from the cache fails with ErrNotCached.
+type offlineTarget struct {
+	cache *oci.Store
+}
+
+// errNoCache is returned by an offline target without a cache
+var errNoCache = errors.New("offline mode requires a cache, see WithCache")
+
+// Fetch fails, as content is read from the cache wrapping the offline target (see src) before it gets here.
+func (t offlineTarget) Fetch(_ context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if t.cache == nil {
+		return nil, errNoCache
+	}
+	if title := desc.Annotations[ocispec.AnnotationTitle]; title != "" {
+		return nil, fmt.Errorf("%s (%s %s): %w", title, desc.MediaType, desc.Digest, ErrNotCached)
+	}
+	return nil, fmt.Errorf("%s %s: %w", desc.MediaType, desc.Digest, ErrNotCached)
+}
+
+// Exists returns true if the described content exists in the cache.
+func (t offlineTarget) Exists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
+	if t.cache == nil {
+		return false, errNoCache
+	}
+	return t.cache.Exists(ctx, desc)
+}
+
+// Resolve resolves a reference recorded in the cache.
+func (t offlineTarget) Resolve(ctx context.Context, reference string) (ocispec.Descriptor, error) {
+	if t.cache == nil {
+		return ocispec.Descriptor{}, errNoCache
+	}
+	desc, err := t.cache.Resolve(ctx, reference)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("resolution of %s: %w", reference, ErrNotCached)
+	}
+	return desc, nil
+}
+
+// platformString formats the platform as os/arch[/variant].
+func platformString(platform ocispec.Platform) string {
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	return s
+}
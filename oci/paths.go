@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// UnsafePathError is returned when a layer title can not be safely written within the destination directory.
+type UnsafePathError struct {
+	Title  string
+	Reason string
+}
+
+// Error returns the error message.
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe layer title %q: %s", e.Title, e.Reason)
+}
+
+// WithStrictPaths enables a stricter extraction policy for layer titles, intended for untrusted registries.
+//
+// By default titles must stay within the destination directory once cleaned, symlinked parent directories
+// must not resolve outside of it, and every title in the root manifest must be safe and unique once cleaned before
+// anything is pulled. In strict mode titles must also already be in canonical slash-separated form, and no symlinks
+// are followed at all.
+func WithStrictPaths(strict bool) Modifier {
+	return func(o *OrasRemote) {
+		o.strictPaths = strict
+	}
+}
+
+// layerPath returns the path the layer titled title is written to within destinationDir, enforcing the extraction policy.
+func (o *OrasRemote) layerPath(destinationDir string, title string) (string, error) {
+	if err := o.validateTitle(title); err != nil {
+		return "", err
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(title))
+	if err := o.checkSymlinks(destinationDir, rel); err != nil {
+		return "", &UnsafePathError{Title: title, Reason: err.Error()}
+	}
+	return filepath.Join(destinationDir, rel), nil
+}
+
+// validateTitle checks that the title is a relative path that does not traverse outside of its root.
+func (o *OrasRemote) validateTitle(title string) error {
+	if title == "" {
+		return errors.New("failed to pull layer: layer is not a file")
+	}
+	rel := filepath.FromSlash(title)
+	if strings.HasPrefix(title, "/") || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return &UnsafePathError{Title: title, Reason: "path is absolute"}
+	}
+	if !filepath.IsLocal(rel) {
+		return &UnsafePathError{Title: title, Reason: "path traverses outside of the destination directory"}
+	}
+	if filepath.Clean(rel) == "." {
+		return &UnsafePathError{Title: title, Reason: "path is the destination directory"}
+	}
+	if !o.strictPaths {
+		return nil
+	}
+	if strings.ContainsRune(title, '\\') {
+		return &UnsafePathError{Title: title, Reason: "path contains a backslash"}
+	}
+	if strings.ContainsFunc(title, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		return &UnsafePathError{Title: title, Reason: "path contains a control character"}
+	}
+	if path.Clean(title) != title {
+		return &UnsafePathError{Title: title, Reason: "path is not in canonical form"}
+	}
+	return nil
+}
+
+// checkSymlinks walks rel from destinationDir and rejects any existing symlink that would redirect the write.
+func (o *OrasRemote) checkSymlinks(destinationDir string, rel string) error {
+	root, err := filepath.EvalSymlinks(destinationDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing below the destination exists yet
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	components := strings.Split(rel, string(filepath.Separator))
+	current := destinationDir
+	for idx, component := range components {
+		current = filepath.Join(current, component)
+		last := idx == len(components)-1
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if o.strictPaths {
+			return fmt.Errorf("%s is a symlink", current)
+		}
+		// the file itself is replaced rather than written through, only parent directories matter
+		if last {
+			return nil
+		}
+		resolved, err := filepath.EvalSymlinks(current)
+		if err != nil {
+			return err
+		}
+		within, err := filepath.Rel(root, resolved)
+		if err != nil || !filepath.IsLocal(within) {
+			return fmt.Errorf("parent directory %s is a symlink outside of the destination directory", current)
+		}
+	}
+	return nil
+}
+
+// validateManifestTitles checks every titled layer in the manifest against the extraction policy and for titles that
+// are written to the same path.
+func (o *OrasRemote) validateManifestTitles(manifest *Manifest) error {
+	seen := map[string]bool{}
+	for _, layer := range manifest.Layers {
+		title, ok := layer.Annotations[ocispec.AnnotationTitle]
+		if !ok {
+			continue
+		}
+		if title == "" {
+			return &UnsafePathError{Title: title, Reason: "title is empty"}
+		}
+		if err := o.validateTitle(title); err != nil {
+			return err
+		}
+		cleaned := path.Clean(filepath.ToSlash(title))
+		if seen[cleaned] {
+			return &UnsafePathError{Title: title, Reason: "title is used by more than one layer"}
+		}
+		seen[cleaned] = true
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func TestLayerPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		title       string
+		strict      bool
+		expected    string
+		expectedErr bool
+	}{
+		{name: "file", title: "file", expected: "file"},
+		{name: "nested file", title: "dir/file", expected: filepath.Join("dir", "file")},
+		{name: "contained traversal", title: "dir/../file", expected: "file"},
+		{name: "traversal", title: "../../etc/x", expectedErr: true},
+		{name: "nested traversal", title: "dir/../../x", expectedErr: true},
+		{name: "absolute", title: "/etc/x", expectedErr: true},
+		{name: "strict file", title: "dir/file", strict: true, expected: filepath.Join("dir", "file")},
+		{name: "strict contained traversal", title: "dir/../file", strict: true, expectedErr: true},
+		{name: "strict dot prefix", title: "./file", strict: true, expectedErr: true},
+		{name: "strict backslash", title: `dir\file`, strict: true, expectedErr: true},
+		{name: "strict control character", title: "fi\nle", strict: true, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			o := &OrasRemote{strictPaths: tt.strict}
+			p, err := o.layerPath(dir, tt.title)
+			if tt.expectedErr {
+				var pathErr *UnsafePathError
+				require.ErrorAs(t, err, &pathErr)
+				require.Equal(t, tt.title, pathErr.Title)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(dir, tt.expected), p)
+		})
+	}
+}
+
+func TestLayerPathSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "real"), helpers.ReadExecuteAllWriteUser))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "inside")))
+
+	o := &OrasRemote{}
+	_, err := o.layerPath(dir, "escape/x")
+	var pathErr *UnsafePathError
+	require.ErrorAs(t, err, &pathErr)
+	p, err := o.layerPath(dir, "inside/x")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "inside", "x"), p)
+
+	strict := &OrasRemote{strictPaths: true}
+	_, err = strict.layerPath(dir, "inside/x")
+	require.ErrorAs(t, err, &pathErr)
+	_, err = strict.layerPath(dir, "real/x")
+	require.NoError(t, err)
+}
+
+func TestValidateManifestTitles(t *testing.T) {
+	layer := func(title string) ocispec.Descriptor {
+		return ocispec.Descriptor{Annotations: map[string]string{ocispec.AnnotationTitle: title}}
+	}
+	o := &OrasRemote{strictPaths: true}
+	require.NoError(t, o.validateManifestTitles(&Manifest{ocispec.Manifest{Layers: []ocispec.Descriptor{layer("a"), layer("b/c"), {}}}}))
+
+	var pathErr *UnsafePathError
+	err := o.validateManifestTitles(&Manifest{ocispec.Manifest{Layers: []ocispec.Descriptor{layer("a"), layer("a")}}})
+	require.ErrorAs(t, err, &pathErr)
+	err = o.validateManifestTitles(&Manifest{ocispec.Manifest{Layers: []ocispec.Descriptor{layer("a"), layer("../b")}}})
+	require.ErrorAs(t, err, &pathErr)
+
+	// titles are compared once cleaned, in every mode
+	lax := &OrasRemote{}
+	for _, layers := range [][]ocispec.Descriptor{
+		{layer("a/b"), layer("a/./b")},
+		{layer("a"), layer("./a")},
+		{layer(".")},
+		{layer("a/..")},
+		{layer("")},
+	} {
+		err = lax.validateManifestTitles(&Manifest{ocispec.Manifest{Layers: layers}})
+		require.ErrorAs(t, err, &pathErr)
+	}
+}
+
+func (suite *OCISuite) TestPullPathUnsafeTitle() {
+	ctx := context.TODO()
+	desc, err := suite.remote.PushLayer(ctx, []byte("malicious"), ocispec.MediaTypeImageLayer)
+	suite.NoError(err)
+	desc.Annotations = map[string]string{ocispec.AnnotationTitle: "../escaped-file"}
+
+	parent := suite.T().TempDir()
+	dstTempDir := filepath.Join(parent, "dst")
+	err = suite.remote.PullPath(ctx, dstTempDir, *desc)
+	var pathErr *UnsafePathError
+	suite.ErrorAs(err, &pathErr)
+	suite.NoFileExists(filepath.Join(parent, "escaped-file"))
+	suite.NoFileExists(filepath.Join(parent, "escaped-file"+partialSuffix))
+}
@@ -6,6 +6,8 @@ package oci
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
@@ -34,3 +36,52 @@ func (o *OrasRemote) printLayer(desc ocispec.Descriptor, suffix string) error {
 	o.log.Debug("operation successful", "layer", layerInfo, "operation", suffix)
 	return nil
 }
+
+// writeSkippedProgress writes size bytes to progressBar for a layer that did not need to be transferred, without
+// holding the whole layer in memory.
+func writeSkippedProgress(progressBar io.Writer, size int64) {
+	_, _ = io.CopyN(progressBar, zeroReader{}, size)
+}
+
+// zeroReader reads an endless stream of zero bytes.
+type zeroReader struct{}
+
+// Read fills p with zero bytes.
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// syncProgressWriter serializes calls to a ProgressWriter that is shared between goroutines.
+type syncProgressWriter struct {
+	helpers.ProgressWriter
+	mu sync.Mutex
+}
+
+// Write writes to the underlying progress writer.
+func (s *syncProgressWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ProgressWriter.Write(p)
+}
+
+// Updatef updates the underlying progress writer.
+func (s *syncProgressWriter) Updatef(format string, a ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ProgressWriter.Updatef(format, a...)
+}
+
+// Successf marks the underlying progress writer as successful.
+func (s *syncProgressWriter) Successf(format string, a ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ProgressWriter.Successf(format, a...)
+}
+
+// Failf marks the underlying progress writer as failed.
+func (s *syncProgressWriter) Failf(format string, a ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ProgressWriter.Failf(format, a...)
+}
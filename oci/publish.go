@@ -0,0 +1,300 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+// PushOption is a function that modifies the options used by PushFiles and PushDirectory
+type PushOption func(*pushOptions)
+
+type pushOptions struct {
+	annotations     map[string]string
+	configMediaType string
+	layerMediaType  string
+	compression     Compression
+}
+
+// WithPushAnnotations sets the annotations of the pushed manifest and its config.
+//
+// The title annotation defaults to the last element of the repository name.
+func WithPushAnnotations(annotations map[string]string) PushOption {
+	return func(opts *pushOptions) {
+		opts.annotations = annotations
+	}
+}
+
+// WithPushConfigMediaType sets the media type of the pushed manifest config, application/vnd.unknown.config.v1+json by default.
+func WithPushConfigMediaType(mediaType string) PushOption {
+	return func(opts *pushOptions) {
+		opts.configMediaType = mediaType
+	}
+}
+
+// WithPushLayerMediaType sets the media type of the pushed file layers, application/vnd.oci.image.layer.v1.tar by default.
+func WithPushLayerMediaType(mediaType string) PushOption {
+	return func(opts *pushOptions) {
+		opts.layerMediaType = mediaType
+	}
+}
+
+// PushDirectory pushes every regular file within dir as a layer titled with its slash-separated path relative to dir,
+// see PushFiles.
+func (o *OrasRemote) PushDirectory(ctx context.Context, tag string, dir string, opts ...PushOption) (ocispec.Descriptor, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("unable to push %s: not a regular file", path)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(paths) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("unable to push %s: directory contains no files", dir)
+	}
+	return o.PushFiles(ctx, tag, dir, paths, opts...)
+}
+
+// PushFiles pushes the files at paths relative to dir as layers titled with their slash-separated paths, packs them
+// into a manifest and adds it to the index at tag for the target platform, returning the published manifest descriptor.
+//
+// A path to a directory is pushed as a single tar layer that is unpacked when pulled, see PushDirectoryLayer.
+//
+// Up to the configured concurrency (see WithConcurrency) layers are pushed in parallel, layers the registry already
+// has are skipped. Progress is written to the progress writer set with SetProgressWriter.
+func (o *OrasRemote) PushFiles(ctx context.Context, tag string, dir string, paths []string, opts ...PushOption) (ocispec.Descriptor, error) {
+	ctx = withoutMirrors(ctx)
+	pushOpts := pushOptions{
+		configMediaType: oras.MediaTypeUnknownConfig,
+		layerMediaType:  ocispec.MediaTypeImageLayer,
+	}
+	for _, opt := range opts {
+		opt(&pushOpts)
+	}
+	annotations := map[string]string{}
+	for k, v := range pushOpts.annotations {
+		annotations[k] = v
+	}
+	if annotations[ocispec.AnnotationTitle] == "" {
+		annotations[ocispec.AnnotationTitle] = path.Base(o.repo.Reference.Repository)
+	}
+
+	switch pushOpts.compression {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported compression %q", pushOpts.compression)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "oci-push-*")
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+	layers, sources, err := o.describeFiles(dir, paths, pushOpts.layerMediaType, pushOpts.compression, tmpDir)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	// the progress writer is serialized by SetProgressWriter, as layers are pushed concurrently
+	bar := o.progTransport.ProgressBar
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(o.concurrency)
+	var pushed atomic.Int64
+	for idx, layer := range layers {
+		eg.Go(func() error {
+			if err := o.pushFile(ectx, sources[idx], layer); err != nil {
+				return err
+			}
+			if bar != nil {
+				bar.Updatef("[%d/%d] layers pushed", pushed.Add(1), len(layers))
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	configDesc, err := o.CreateAndPushManifestConfig(ctx, annotations, pushOpts.configMediaType)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	packOpts := oras.PackManifestOptions{
+		Layers:              layers,
+		ConfigDescriptor:    configDesc,
+		ManifestAnnotations: annotations,
+	}
+	manifestDesc, err := oras.PackManifest(ctx, o.repo, oras.PackManifestVersion1_1, "", packOpts)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to push manifest to %s: %w", o.repo.Reference, err)
+	}
+
+	if err := o.UpdateIndex(ctx, tag, manifestDesc); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return manifestDesc, nil
+}
+
+// describeFiles returns the layer descriptors of the files at paths relative to dir, once compressed with compression,
+// and the paths their content is pushed from.
+//
+// Directories are described as a single tar layer (see PushDirectoryLayer), written to tmpDir along with the compressed
+// content of every layer.
+func (o *OrasRemote) describeFiles(dir string, paths []string, mediaType string, compression Compression, tmpDir string) ([]ocispec.Descriptor, []string, error) {
+	layers := make([]ocispec.Descriptor, 0, len(paths))
+	sources := make([]string, 0, len(paths))
+	titles := map[string]bool{}
+	for _, p := range paths {
+		title := filepath.ToSlash(filepath.Clean(p))
+		if err := o.validateTitle(title); err != nil {
+			return nil, nil, err
+		}
+		if titles[title] {
+			return nil, nil, &UnsafePathError{Title: title, Reason: "title is used by more than one layer"}
+		}
+		titles[title] = true
+
+		source := filepath.Join(dir, p)
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return nil, nil, errors.Join(err, f.Close())
+		}
+		if info.IsDir() {
+			if err := f.Close(); err != nil {
+				return nil, nil, err
+			}
+			tarPath := filepath.Join(tmpDir, fmt.Sprintf("%d.tar", len(layers)))
+			layer, layerPath, err := describeDirectory(source, title, tarPath, compression)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to archive %s: %w", p, err)
+			}
+			layers = append(layers, layer)
+			sources = append(sources, layerPath)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			return nil, nil, errors.Join(fmt.Errorf("unable to push %s: not a regular file", p), f.Close())
+		}
+		compressedPath := filepath.Join(tmpDir, fmt.Sprintf("%d.%s", len(layers), compression))
+		layer, err := describeFile(f, info.Size(), mediaType, compression, compressedPath)
+		if err := errors.Join(err, f.Close()); err != nil {
+			return nil, nil, fmt.Errorf("failed to digest %s: %w", p, err)
+		}
+		layer.Annotations[ocispec.AnnotationTitle] = title
+		layers = append(layers, layer)
+		if compression != CompressionNone {
+			source = compressedPath
+		}
+		sources = append(sources, source)
+	}
+	return layers, sources, nil
+}
+
+// describeFile returns the layer descriptor of the size bytes read from r, once compressed with compression.
+//
+// The compressed content is written to compressedPath, for the layer to be pushed from.
+func describeFile(r io.Reader, size int64, mediaType string, compression Compression, compressedPath string) (ocispec.Descriptor, error) {
+	if compression == CompressionNone {
+		dgst, err := digest.FromReader(r)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: size, Annotations: map[string]string{}}, nil
+	}
+
+	f, err := os.OpenFile(compressedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, helpers.ReadWriteUser)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	uncompressed := digest.Canonical.Digester()
+	uncompressedCounter := &countingWriter{}
+	compressed := digest.Canonical.Digester()
+	counter := &countingWriter{}
+	err = compressTo(io.MultiWriter(f, compressed.Hash(), counter), io.TeeReader(r, io.MultiWriter(uncompressed.Hash(), uncompressedCounter)), compression)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return ocispec.Descriptor{
+		MediaType: compressedMediaType(mediaType, compression),
+		Digest:    compressed.Digest(),
+		Size:      counter.n,
+		Annotations: map[string]string{
+			AnnotationUncompressedDigest: uncompressed.Digest().String(),
+			AnnotationUncompressedSize:   strconv.FormatInt(uncompressedCounter.n, 10),
+		},
+	}, nil
+}
+
+// pushFile pushes the file at path, which holds the content of layer as it is pushed, unless the registry already has it.
+func (o *OrasRemote) pushFile(ctx context.Context, path string, layer ocispec.Descriptor) error {
+	exists, err := o.blobExists(ctx, layer)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if bar := o.progTransport.ProgressBar; bar != nil {
+			writeSkippedProgress(bar, layer.Size)
+		}
+		return o.printLayerSkipped(ctx, layer)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := o.repo.Push(ctx, layer, f); err != nil {
+		return fmt.Errorf("failed to push %s to %s: %w", layer.Annotations[ocispec.AnnotationTitle], o.repo.Reference, err)
+	}
+	return o.printLayerCopied(ctx, layer)
+}
+
+// blobExists checks if the registry has the blob described by desc, bypassing mirrors and the progress writer so
+// callers report skipped blobs themselves.
+func (o *OrasRemote) blobExists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
+	repo := &remote.Repository{Client: o.repo.Client, Reference: o.repo.Reference, PlainHTTP: o.repo.PlainHTTP}
+	if client, ok := o.authClient(); ok {
+		quiet := *client
+		httpClient := *client.Client
+		httpClient.Transport = o.progTransport.Base
+		quiet.Client = &httpClient
+		repo.Client = &quiet
+	}
+	return repo.Blobs().Exists(withoutMirrors(ctx), desc)
+}
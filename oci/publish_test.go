@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func (suite *OCISuite) TestPushDirectory() {
+	ctx := context.TODO()
+	url := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+
+	files := map[string]string{
+		"zarf.yaml":                "kind: ZarfPackageConfig",
+		"components/first.tar":     "first component",
+		"components/second.tar":    "second component",
+		"images/blobs/sha256/0123": "an image layer",
+	}
+	srcDir := suite.T().TempDir()
+	size := 0
+	for name, contents := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		suite.NoError(helpers.CreateDirectory(filepath.Dir(path), helpers.ReadExecuteAllWriteUser))
+		suite.NoError(os.WriteFile(path, []byte(contents), helpers.ReadWriteUser))
+		size += len(contents)
+	}
+
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	progress := &TestProgressWriter{}
+	remote.SetProgressWriter(progress)
+	desc, err := remote.PushDirectory(ctx, "0.0.1", srcDir,
+		WithPushAnnotations(map[string]string{ocispec.AnnotationDescription: "pushed directory"}))
+	suite.NoError(err)
+	suite.GreaterOrEqual(progress.bytesSent, size)
+
+	// the published manifest is in the index at tag
+	pulled, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	root, err := pulled.ResolveRoot(ctx)
+	suite.NoError(err)
+	suite.Equal(desc.Digest, root.Digest)
+	manifest, err := pulled.FetchRoot(ctx)
+	suite.NoError(err)
+	suite.Equal("package", manifest.Annotations[ocispec.AnnotationTitle])
+	suite.Equal("pushed directory", manifest.Annotations[ocispec.AnnotationDescription])
+	suite.Equal(oras.MediaTypeUnknownConfig, manifest.Config.MediaType)
+	suite.Len(manifest.Layers, len(files))
+
+	dstDir := suite.T().TempDir()
+	paths := []string{}
+	for name := range files {
+		paths = append(paths, name)
+	}
+	_, err = pulled.PullPaths(ctx, dstDir, paths)
+	suite.NoError(err)
+	for name, contents := range files {
+		b, err := os.ReadFile(filepath.Join(dstDir, filepath.FromSlash(name)))
+		suite.NoError(err)
+		suite.Equal(contents, string(b))
+	}
+
+	// pushing a subset again skips the existing layers
+	desc, err = remote.PushFiles(ctx, "subset", srcDir, []string{"zarf.yaml", filepath.Join("components", "first.tar")},
+		WithPushLayerMediaType("application/vnd.example.layer"))
+	suite.NoError(err)
+	subset, err := remote.FetchManifest(ctx, desc)
+	suite.NoError(err)
+	suite.Len(subset.Layers, 2)
+	suite.Equal("components/first.tar", subset.Layers[1].Annotations[ocispec.AnnotationTitle])
+	suite.Equal("application/vnd.example.layer", subset.Layers[1].MediaType)
+
+	// an existing layer is reported to the progress writer once, by its size
+	skipped := &TestProgressWriter{}
+	remote.SetProgressWriter(skipped)
+	suite.NoError(remote.pushFile(ctx, filepath.Join(srcDir, "zarf.yaml"), manifest.Locate("zarf.yaml")))
+	suite.Equal(len(files["zarf.yaml"]), skipped.bytesSent)
+
+	var unsafe *UnsafePathError
+	_, err = remote.PushFiles(ctx, "unsafe", srcDir, []string{filepath.Join("..", "outside")})
+	suite.ErrorAs(err, &unsafe)
+	_, err = remote.PushFiles(ctx, "duplicate", srcDir, []string{"zarf.yaml", "./zarf.yaml"})
+	suite.ErrorAs(err, &unsafe)
+	_, err = remote.PushDirectory(ctx, "empty", suite.T().TempDir())
+	suite.ErrorContains(err, "directory contains no files")
+}
+
+func (suite *OCISuite) TestPushCompression() {
+	ctx := context.TODO()
+	url := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+	srcDir := suite.T().TempDir()
+	contents := strings.Repeat("kind: ZarfPackageConfig\n", 1000)
+	suite.NoError(os.WriteFile(filepath.Join(srcDir, "zarf.yaml"), []byte(contents), helpers.ReadWriteUser))
+	uncompressed := digest.FromString(contents)
+
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+		suite.NoError(err)
+		desc, err := remote.PushDirectory(ctx, string(compression), srcDir, WithPushCompression(compression))
+		suite.NoError(err)
+		// compression is reproducible
+		again, err := remote.PushDirectory(ctx, string(compression), srcDir, WithPushCompression(compression))
+		suite.NoError(err)
+		suite.Equal(desc.Digest, again.Digest)
+
+		manifest, err := remote.FetchManifest(ctx, desc)
+		suite.NoError(err)
+		layer := manifest.Locate("zarf.yaml")
+		suite.Equal(ocispec.MediaTypeImageLayer+"+"+string(compression), layer.MediaType)
+		suite.Equal(uncompressed.String(), layer.Annotations[AnnotationUncompressedDigest])
+		suite.Less(layer.Size, int64(len(contents)))
+
+		dstDir := suite.T().TempDir()
+		_, err = remote.PullPaths(ctx, dstDir, []string{"zarf.yaml"})
+		suite.NoError(err)
+		b, err := os.ReadFile(filepath.Join(dstDir, "zarf.yaml"))
+		suite.NoError(err)
+		suite.Equal(contents, string(b))
+		suite.True(remote.FileDescriptorExists(layer, dstDir))
+	}
+
+	// a layer whose content does not match its uncompressed digest is rejected
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	var buf bytes.Buffer
+	suite.NoError(compressTo(&buf, strings.NewReader("tampered"), CompressionGzip))
+	layer, err := remote.PushLayer(ctx, buf.Bytes(), ocispec.MediaTypeImageLayerGzip)
+	suite.NoError(err)
+	layer.Annotations = map[string]string{
+		ocispec.AnnotationTitle:      "tampered",
+		AnnotationUncompressedDigest: uncompressed.String(),
+	}
+	dstDir := suite.T().TempDir()
+	err = remote.PullPath(ctx, dstDir, *layer)
+	suite.ErrorIs(err, content.ErrMismatchedDigest)
+	suite.NoFileExists(filepath.Join(dstDir, "tampered"))
+
+	_, err = remote.PushDirectory(ctx, "bzip2", srcDir, WithPushCompression("bzip2"))
+	suite.ErrorContains(err, "unsupported compression")
+}
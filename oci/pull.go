@@ -8,20 +8,34 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
 
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
 
 	"github.com/defenseunicorns/pkg/helpers/v2"
 )
 
+// partialSuffix is appended to the destination path of a layer while it is being pulled
+const partialSuffix = ".partial"
+
 // FileDescriptorExists returns true if the given file exists in the given directory with the expected SHA.
+//
+// For a directory layer (see AnnotationUnpack) the directory must archive to the tar the layer was pushed from.
 func (o *OrasRemote) FileDescriptorExists(desc ocispec.Descriptor, destinationDir string) bool {
-	rel := desc.Annotations[ocispec.AnnotationTitle]
-	destinationPath := filepath.Join(destinationDir, rel)
+	destinationPath, err := o.layerPath(destinationDir, desc.Annotations[ocispec.AnnotationTitle])
+	if err != nil {
+		return false
+	}
+	if isDirectoryLayer(desc) {
+		return directoryMatches(destinationPath, desc)
+	}
 
 	info, err := os.Stat(destinationPath)
 	if err != nil {
@@ -30,7 +44,15 @@ func (o *OrasRemote) FileDescriptorExists(desc ocispec.Descriptor, destinationDi
 	if info.IsDir() {
 		return false
 	}
-	if info.Size() != desc.Size {
+
+	// compressed layers are written decompressed, so only the uncompressed digest can be checked
+	expected := desc.Digest
+	if compression, uncompressed := layerCompression(desc); compression != CompressionNone {
+		expected = uncompressed
+	} else if info.Size() != desc.Size {
+		return false
+	}
+	if expected.Validate() != nil {
 		return false
 	}
 
@@ -40,11 +62,11 @@ func (o *OrasRemote) FileDescriptorExists(desc ocispec.Descriptor, destinationDi
 	}
 	defer f.Close()
 
-	actual, err := helpers.GetSHA256Hash(f)
+	actual, err := expected.Algorithm().FromReader(f)
 	if err != nil {
 		return false
 	}
-	return actual == desc.Digest.Encoded()
+	return actual == expected
 }
 
 // CopyToTarget copies the given layers from the remote repository to the given target
@@ -79,56 +101,211 @@ func (o *OrasRemote) CopyToTarget(ctx context.Context, layers []ocispec.Descript
 }
 
 // PullPath pulls a layer from the remote repository and saves it to `destinationDir/annotationTitle`.
+//
+// The title must resolve to a path within destinationDir, otherwise an *UnsafePathError is returned (see WithStrictPaths).
+//
+// The layer is downloaded to `destinationDir/annotationTitle.partial` and renamed into place once its digest is verified.
+// If a previous pull was interrupted, the download resumes from the end of the partial file when the registry supports range requests.
+//
+// Directory layers (see AnnotationUnpack) are unpacked to `destinationDir/annotationTitle`, replacing its contents.
 func (o *OrasRemote) PullPath(ctx context.Context, destinationDir string, desc ocispec.Descriptor) error {
-	vr, err := o.FetchLayerReader(ctx, desc)
+	fullPath, err := o.layerPath(destinationDir, desc.Annotations[ocispec.AnnotationTitle])
+	if err != nil {
+		return err
+	}
+	dirPath := filepath.Dir(fullPath)
+	if err := helpers.CreateDirectory(dirPath, helpers.ReadExecuteAllWriteUser); err != nil {
+		return err
+	}
+	if isDirectoryLayer(desc) {
+		return o.pullDirectory(ctx, fullPath, desc)
+	}
+
+	// a partial file is only ever written by a pull, never follow a symlink in its place
+	partialPath := fullPath + partialSuffix
+	if info, err := os.Lstat(partialPath); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(partialPath); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, helpers.ReadWriteUser)
+	if err != nil {
+		return err
+	}
+	err = o.downloadLayer(ctx, file, desc)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	rel := desc.Annotations[ocispec.AnnotationTitle]
-	if rel == "" {
-		return errors.New("failed to pull layer: layer is not a file")
+	return os.Rename(partialPath, fullPath)
+}
+
+// downloadLayer writes the layer to file, resuming from the current end of file when possible.
+//
+// The partial file is kept on read failures so the download can be resumed, and discarded if the content fails verification.
+func (o *OrasRemote) downloadLayer(ctx context.Context, file *os.File, desc ocispec.Descriptor) error {
+	if compression, uncompressed := layerCompression(desc); compression != CompressionNone {
+		return o.downloadCompressedLayer(ctx, file, desc, compression, uncompressed)
 	}
 
-	fullPath := filepath.Join(destinationDir, rel)
-	dirPath := filepath.Dir(fullPath)
-	if err := helpers.CreateDirectory(dirPath, helpers.ReadExecuteAllWriteUser); err != nil {
+	info, err := file.Stat()
+	if err != nil {
 		return err
 	}
+	offset := info.Size()
+	if offset > desc.Size {
+		offset = 0
+	}
 
-	file, err := os.OpenFile(fullPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, helpers.ReadWriteUser)
+	rc, err := o.Fetch(ctx, desc)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer rc.Close()
+
+	if offset > 0 {
+		// seeking issues a range request against the blob endpoint, if the source is
+		// not seekable or the request fails the layer is downloaded from the start
+		seeker, ok := rc.(io.Seeker)
+		if !ok {
+			offset = 0
+		} else if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			offset = 0
+		}
+	}
+
+	digester := desc.Digest.Algorithm().Digester()
+	if offset > 0 {
+		if o.log != nil {
+			o.log.Debug("resuming layer download", "digest", desc.Digest, "offset", offset, "size", desc.Size)
+		}
+		// the bytes already on disk are part of the digest
+		if _, err := io.Copy(digester.Hash(), io.NewSectionReader(file, 0, offset)); err != nil {
+			return err
+		}
+	}
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
 
-	if _, err := io.Copy(file, vr); err != nil {
+	n, err := io.Copy(io.MultiWriter(file, digester.Hash()), rc)
+	if err != nil {
 		return fmt.Errorf("read failed: %w", err)
 	}
 
-	return vr.Verify()
+	if offset+n != desc.Size {
+		err = fmt.Errorf("%s: expected %d bytes, received %d: %w", desc.Digest, desc.Size, offset+n, content.ErrInvalidDescriptorSize)
+	} else if digester.Digest() != desc.Digest {
+		err = fmt.Errorf("%s: %w", desc.Digest, content.ErrMismatchedDigest)
+	}
+	if err != nil {
+		// the partial content can't be trusted, start over on the next pull
+		return errors.Join(err, file.Truncate(0))
+	}
+	return nil
+}
+
+// downloadCompressedLayer writes the decompressed layer to file, verifying the digests of both the layer and its
+// uncompressed content.
+//
+// Compressed layers can not be resumed, the download always starts over.
+func (o *OrasRemote) downloadCompressedLayer(ctx context.Context, file *os.File, desc ocispec.Descriptor, compression Compression, uncompressed digest.Digest) error {
+	if err := uncompressed.Validate(); err != nil {
+		return fmt.Errorf("%s: invalid uncompressed digest: %w", desc.Digest, err)
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	rc, err := o.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	compressedDigester := desc.Digest.Algorithm().Digester()
+	counter := &countingWriter{}
+	dr, err := decompressReader(io.TeeReader(rc, io.MultiWriter(compressedDigester.Hash(), counter)), compression)
+	if err != nil {
+		return errors.Join(fmt.Errorf("%s: %w", desc.Digest, err), file.Truncate(0))
+	}
+	defer dr.Close()
+
+	uncompressedDigester := uncompressed.Algorithm().Digester()
+	if _, err := io.Copy(io.MultiWriter(file, uncompressedDigester.Hash()), dr); err != nil {
+		return errors.Join(fmt.Errorf("read failed: %w", err), file.Truncate(0))
+	}
+	// read anything after the end of the compressed stream so it is part of the layer digest
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return errors.Join(fmt.Errorf("read failed: %w", err), file.Truncate(0))
+	}
+
+	if counter.n != desc.Size {
+		err = fmt.Errorf("%s: expected %d bytes, received %d: %w", desc.Digest, desc.Size, counter.n, content.ErrInvalidDescriptorSize)
+	} else if compressedDigester.Digest() != desc.Digest {
+		err = fmt.Errorf("%s: %w", desc.Digest, content.ErrMismatchedDigest)
+	} else if uncompressedDigester.Digest() != uncompressed {
+		err = fmt.Errorf("%s: uncompressed content %s: %w", desc.Digest, uncompressed, content.ErrMismatchedDigest)
+	}
+	if err != nil {
+		return errors.Join(err, file.Truncate(0))
+	}
+	return nil
 }
 
 // PullPaths pulls multiple files from the remote repository and saves them to `destinationDir`.
+//
+// Up to the configured concurrency (see WithConcurrency) layers are pulled in parallel.
 func (o *OrasRemote) PullPaths(ctx context.Context, destinationDir string, paths []string) ([]ocispec.Descriptor, error) {
 	paths = helpers.Unique(paths)
 	root, err := o.FetchRoot(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if err := o.validateManifestTitles(root); err != nil {
+		return nil, err
+	}
 	layersPulled := []ocispec.Descriptor{}
 	for _, path := range paths {
 		desc := root.Locate(path)
 		if !IsEmptyDescriptor(desc) {
 			layersPulled = append(layersPulled, desc)
-			if o.FileDescriptorExists(desc, destinationDir) {
-				continue
-			}
-			err = o.PullPath(ctx, destinationDir, desc)
-			if err != nil {
-				return nil, err
+		}
+	}
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(o.concurrency)
+	// a layer can be located by both its title and digest, only pull it once
+	seen := map[string]ocispec.Descriptor{}
+	for _, desc := range layersPulled {
+		title := desc.Annotations[ocispec.AnnotationTitle]
+		if prev, ok := seen[title]; ok {
+			if prev.Digest != desc.Digest {
+				return nil, &UnsafePathError{Title: title, Reason: "title is used by more than one layer"}
 			}
+			continue
 		}
+		seen[title] = desc
+	}
+	for _, desc := range seen {
+		eg.Go(func() error {
+			if o.FileDescriptorExists(desc, destinationDir) {
+				return nil
+			}
+			return o.PullPath(ectx, destinationDir, desc)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
 	}
 	return layersPulled, nil
 }
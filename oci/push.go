@@ -9,6 +9,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"time"
 
 	"github.com/opencontainers/image-spec/specs-go"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -18,6 +22,19 @@ import (
 	"oras.land/oras-go/v2/errdef"
 )
 
+const (
+	// defaultIndexUpdateAttempts is the default number of times UpdateIndex merges into a concurrently modified index
+	defaultIndexUpdateAttempts = 5
+	// indexRetryInterval is the base delay between attempts to update a concurrently modified index
+	indexRetryInterval = 100 * time.Millisecond
+)
+
+// ErrIndexConflict is returned when an index keeps being modified concurrently while UpdateIndex merges into it
+var ErrIndexConflict = errors.New("index was modified concurrently")
+
+// errIndexChanged is returned when an index changed during a single update attempt
+var errIndexChanged = errors.New("index changed")
+
 // ConfigPartial is a partial OCI config that is used to create the manifest config.
 //
 // Unless specified, an empty manifest config will be used: `{}`
@@ -56,13 +73,18 @@ func (o *OrasRemote) CreateAndPushManifestConfig(ctx context.Context, annotation
 
 // PackAndTagManifest generates an OCI Image Manifest based on the given parameters
 // pushes that manifest to the remote repository and returns the manifest descriptor.
+//
+// Use WithSubject to make the manifest a referrer of another manifest.
 func (o *OrasRemote) PackAndTagManifest(ctx context.Context, src *file.Store, descs []ocispec.Descriptor,
-	configDesc *ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	configDesc *ocispec.Descriptor, annotations map[string]string, opts ...PackOption) (ocispec.Descriptor, error) {
 	packOpts := oras.PackManifestOptions{
 		Layers:              descs,
 		ConfigDescriptor:    configDesc,
 		ManifestAnnotations: annotations,
 	}
+	for _, opt := range opts {
+		opt(&packOpts)
+	}
 
 	root, err := oras.PackManifest(ctx, src, oras.PackManifestVersion1_1, "", packOpts)
 	if err != nil {
@@ -75,78 +97,258 @@ func (o *OrasRemote) PackAndTagManifest(ctx context.Context, src *file.Store, de
 	return root, nil
 }
 
+// IndexOption is a function that modifies the options used by UpdateIndex
+type IndexOption func(*indexOptions)
+
+type indexOptions struct {
+	annotations  map[string]string
+	artifactType string
+}
+
+// WithIndexAnnotations sets the given annotations on the index, keeping its other annotations.
+func WithIndexAnnotations(annotations map[string]string) IndexOption {
+	return func(opts *indexOptions) {
+		opts.annotations = annotations
+	}
+}
+
+// WithIndexArtifactType sets the artifact type of the index.
+func WithIndexArtifactType(artifactType string) IndexOption {
+	return func(opts *indexOptions) {
+		opts.artifactType = artifactType
+	}
+}
+
 // UpdateIndex updates the index for the given package.
-func (o *OrasRemote) UpdateIndex(ctx context.Context, tag string, publishedDesc ocispec.Descriptor) error {
-	var index ocispec.Index
+//
+// Concurrent updates of the same index, e.g. when publishing each platform from its own job, are detected and merged,
+// ErrIndexConflict is returned if the index is still changing after the attempts set by WithIndexUpdateAttempts.
+//
+// If a signer was set with WithSigner the published manifest is signed before it is added to the index, so the tag
+// never points to an unsigned manifest and is left untouched if signing fails.
+//
+// The annotations and artifact type of the index are kept unless set with WithIndexAnnotations or WithIndexArtifactType.
+func (o *OrasRemote) UpdateIndex(ctx context.Context, tag string, publishedDesc ocispec.Descriptor, opts ...IndexOption) error {
+	if o.signer != nil {
+		if _, err := o.Sign(ctx, publishedDesc, *o.signer); err != nil {
+			return err
+		}
+	}
+	return o.updateIndex(ctx, tag, publishedDesc, o.targetPlatform, opts...)
+}
+
+// updateIndex merges the published manifest into the index at tag under the given platform.
+func (o *OrasRemote) updateIndex(ctx context.Context, tag string, publishedDesc ocispec.Descriptor, platform *ocispec.Platform, opts ...IndexOption) error {
+	indexOpts := indexOptions{}
+	for _, opt := range opts {
+		opt(&indexOpts)
+	}
 
 	o.repo.Reference.Reference = tag
 	// since ref has changed, need to reset root
 	o.root = nil
 
-	_, err := o.repo.Resolve(ctx, o.repo.Reference.Reference)
-	if err != nil {
-		if errors.Is(err, errdef.ErrNotFound) {
-			index = ocispec.Index{
-				MediaType: ocispec.MediaTypeImageIndex,
-				Versioned: specs.Versioned{
-					SchemaVersion: 2,
-				},
-				Manifests: []ocispec.Descriptor{
-					{
-						MediaType: ocispec.MediaTypeImageManifest,
-						Digest:    publishedDesc.Digest,
-						Size:      publishedDesc.Size,
-						Platform:  o.targetPlatform,
-					},
-				},
+	return o.modifyIndex(ctx, tag, func(index *ocispec.Index, _ bool) error {
+		mergeIndex(index, publishedDesc, platform)
+		if len(indexOpts.annotations) > 0 {
+			if index.Annotations == nil {
+				index.Annotations = map[string]string{}
 			}
-			return o.pushIndex(ctx, &index, tag)
+			maps.Copy(index.Annotations, indexOpts.annotations)
 		}
-		return err
+		if indexOpts.artifactType != "" {
+			index.ArtifactType = indexOpts.artifactType
+		}
+		return nil
+	})
+}
+
+// RemoveFromIndex removes the manifest for platform from the index at tag, deleting the index if it was the last one.
+//
+// The platform must match the OS, architecture, variant and OS version of the index entry exactly.
+func (o *OrasRemote) RemoveFromIndex(ctx context.Context, tag string, platform ocispec.Platform) error {
+	if o.repo.Reference.Reference == tag {
+		o.root = nil
 	}
+	return o.modifyIndex(ctx, tag, func(index *ocispec.Index, exists bool) error {
+		if !exists {
+			return fmt.Errorf("index %s: %w", tag, errdef.ErrNotFound)
+		}
+		manifests := slices.DeleteFunc(slices.Clone(index.Manifests), func(m ocispec.Descriptor) bool {
+			return samePlatform(m.Platform, &platform)
+		})
+		if len(manifests) == len(index.Manifests) {
+			return fmt.Errorf("no manifest for platform %s in index %s: %w", platformString(platform), tag, errdef.ErrNotFound)
+		}
+		index.Manifests = manifests
+		return nil
+	})
+}
+
+// modifyIndex applies edit to the index at tag and pushes the result, deleting the index if edit leaves it empty.
+//
+// The index is read, edited and pushed optimistically: if the tag moved while editing, or is overwritten right after
+// the push, the edit is retried against the new index up to o.indexUpdateAttempts times.
+func (o *OrasRemote) modifyIndex(ctx context.Context, tag string, edit func(index *ocispec.Index, exists bool) error) error {
+	ctx = withoutMirrors(ctx)
+	for attempt := 1; attempt <= o.indexUpdateAttempts; attempt++ {
+		if attempt > 1 {
+			if err := waitIndexRetry(ctx, attempt); err != nil {
+				return err
+			}
+		}
 
+		index, base, err := o.fetchIndex(ctx, tag)
+		if err != nil {
+			return err
+		}
+		if err := edit(index, base.Digest != ""); err != nil {
+			return err
+		}
+
+		if len(index.Manifests) == 0 {
+			err = o.deleteIndexIfUnchanged(ctx, tag, base)
+		} else {
+			err = o.pushIndexIfUnchanged(ctx, index, tag, base)
+		}
+		if !errors.Is(err, errIndexChanged) {
+			return err
+		}
+		if o.log != nil {
+			o.log.Debug("index was modified concurrently, retrying", "tag", tag, "attempt", attempt)
+		}
+	}
+	return fmt.Errorf("failed to update index %s after %d attempts: %w", tag, o.indexUpdateAttempts, ErrIndexConflict)
+}
+
+// fetchIndex fetches the index at tag and its descriptor, or a new empty index and an empty descriptor if
+// the tag does not exist.
+func (o *OrasRemote) fetchIndex(ctx context.Context, tag string) (*ocispec.Index, ocispec.Descriptor, error) {
 	desc, rc, err := o.repo.FetchReference(ctx, tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return &ocispec.Index{
+			MediaType: ocispec.MediaTypeImageIndex,
+			Versioned: specs.Versioned{
+				SchemaVersion: 2,
+			},
+		}, ocispec.Descriptor{}, nil
+	}
 	if err != nil {
-		return err
+		return nil, ocispec.Descriptor{}, err
 	}
 	defer rc.Close()
 
 	b, err := content.ReadAll(rc, desc)
 	if err != nil {
-		return err
+		return nil, ocispec.Descriptor{}, err
 	}
 
+	var index ocispec.Index
 	if err := json.Unmarshal(b, &index); err != nil {
-		return err
+		return nil, ocispec.Descriptor{}, err
 	}
+	return &index, desc, nil
+}
 
-	found := false
+// mergeIndex adds publishedDesc to the index under platform, replacing the manifest of the same platform.
+//
+// Index level fields such as annotations and artifactType are left as they are.
+func mergeIndex(index *ocispec.Index, publishedDesc ocispec.Descriptor, platform *ocispec.Platform) {
 	for idx, m := range index.Manifests {
-		if m.Platform != nil && m.Platform.Architecture == o.targetPlatform.Architecture {
+		if samePlatform(m.Platform, platform) {
 			index.Manifests[idx].Digest = publishedDesc.Digest
 			index.Manifests[idx].Size = publishedDesc.Size
-			index.Manifests[idx].Platform = o.targetPlatform
-			found = true
-			break
+			index.Manifests[idx].Platform = platform
+			return
 		}
 	}
-	if !found {
-		index.Manifests = append(index.Manifests, ocispec.Descriptor{
-			MediaType: ocispec.MediaTypeImageManifest,
-			Digest:    publishedDesc.Digest,
-			Size:      publishedDesc.Size,
-			Platform:  o.targetPlatform,
-		})
+	index.Manifests = append(index.Manifests, ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    publishedDesc.Digest,
+		Size:      publishedDesc.Size,
+		Platform:  platform,
+	})
+}
+
+// pushIndexIfUnchanged pushes index to tag, returning errIndexChanged if the tag no longer points to base
+// before the push, or no longer points to the pushed index right after it.
+//
+// Registries do not support conditional manifest pushes, so a small window between the check and the push remains,
+// the check after the push catches writers that raced through it.
+func (o *OrasRemote) pushIndexIfUnchanged(ctx context.Context, index *ocispec.Index, tag string, base ocispec.Descriptor) error {
+	current, err := o.resolveIndex(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if current.Digest != base.Digest {
+		return errIndexChanged
 	}
 
-	return o.pushIndex(ctx, &index, tag)
+	pushed, err := o.pushIndex(ctx, index, tag)
+	if err != nil {
+		return err
+	}
+
+	current, err = o.resolveIndex(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if current.Digest != pushed.Digest {
+		return errIndexChanged
+	}
+	return nil
 }
 
-func (o *OrasRemote) pushIndex(ctx context.Context, index *ocispec.Index, tag string) error {
-	indexBytes, err := json.Marshal(index)
+// deleteIndexIfUnchanged deletes the index at tag, returning errIndexChanged if the tag no longer points to base.
+func (o *OrasRemote) deleteIndexIfUnchanged(ctx context.Context, tag string, base ocispec.Descriptor) error {
+	current, err := o.resolveIndex(ctx, tag)
 	if err != nil {
 		return err
 	}
+	if current.Digest != base.Digest {
+		return errIndexChanged
+	}
+	if err := o.repo.Manifests().Delete(ctx, base); err != nil {
+		return fmt.Errorf("failed to delete index %s: %w", tag, err)
+	}
+	return nil
+}
+
+// samePlatform returns true if a and b have the same OS, architecture, variant and OS version.
+func samePlatform(a, b *ocispec.Platform) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.OS == b.OS && a.Architecture == b.Architecture && a.Variant == b.Variant && a.OSVersion == b.OSVersion
+}
+
+// resolveIndex resolves tag, returning an empty descriptor if it does not exist.
+func (o *OrasRemote) resolveIndex(ctx context.Context, tag string) (ocispec.Descriptor, error) {
+	desc, err := o.repo.Resolve(ctx, tag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return ocispec.Descriptor{}, nil
+	}
+	return desc, err
+}
+
+// waitIndexRetry waits before the given attempt to update an index, with jitter so concurrent writers spread out.
+func waitIndexRetry(ctx context.Context, attempt int) error {
+	delay := time.Duration(attempt-1)*indexRetryInterval + time.Duration(rand.Int64N(int64(indexRetryInterval)))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (o *OrasRemote) pushIndex(ctx context.Context, index *ocispec.Index, tag string) (ocispec.Descriptor, error) {
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
 	indexDesc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, indexBytes)
-	return o.repo.Manifests().PushReference(ctx, indexDesc, bytes.NewReader(indexBytes), tag)
+	return indexDesc, o.repo.Manifests().PushReference(ctx, indexDesc, bytes.NewReader(indexBytes), tag)
 }
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// overwritingTransport pushes another index to tag each time an index is pushed to it, simulating a writer
+// that always wins the race.
+type overwritingTransport struct {
+	http.RoundTripper
+	other  *OrasRemote
+	tag    string
+	pushes int
+}
+
+func (t *overwritingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPut || !strings.HasSuffix(req.URL.Path, "/manifests/"+t.tag) {
+		return resp, err
+	}
+	t.pushes++
+	index := ocispec.Index{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageIndex,
+		Annotations: map[string]string{"push": fmt.Sprint(t.pushes)},
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageIndex, b)
+	if err := t.other.Repo().PushReference(req.Context(), desc, bytes.NewReader(b), t.tag); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (suite *OCISuite) TestUpdateIndexConcurrent() {
+	ctx := context.TODO()
+	url := suite.setupInMemoryRegistry(ctx)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := range 6 {
+		remote, desc := suite.pushTestManifest(url, PlatformForArch(fmt.Sprintf("arch-%d", i)), fmt.Sprintf("concurrent %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- remote.UpdateIndex(ctx, "concurrent", desc)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		suite.NoError(err)
+	}
+
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	index, _, err := remote.fetchIndex(ctx, "concurrent")
+	suite.NoError(err)
+	suite.Len(index.Manifests, 6)
+}
+
+func (suite *OCISuite) TestUpdateIndexConflict() {
+	ctx := context.TODO()
+	url := suite.setupInMemoryRegistry(ctx)
+
+	remote, desc := suite.pushTestManifest(url, PlatformForArch(testArch), "conflict")
+	other, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	transport := &overwritingTransport{RoundTripper: remote.progTransport.Base, other: other, tag: "conflict"}
+	remote.progTransport.Base = transport
+	WithIndexUpdateAttempts(2)(remote)
+
+	err = remote.UpdateIndex(ctx, "conflict", desc)
+	suite.ErrorIs(err, ErrIndexConflict)
+	suite.Equal(2, transport.pushes)
+}
+
+func (suite *OCISuite) TestUpdateIndexPlatforms() {
+	ctx := context.TODO()
+	url := suite.setupInMemoryRegistry(ctx)
+
+	platforms := []ocispec.Platform{
+		{OS: MultiOS, Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"},
+	}
+	var remote *OrasRemote
+	for _, platform := range platforms {
+		var desc ocispec.Descriptor
+		remote, desc = suite.pushTestManifest(url, platform, platformString(platform)+platform.OSVersion)
+		suite.NoError(remote.UpdateIndex(ctx, "platforms", desc))
+	}
+
+	// index level fields are set with options and kept when the index is updated without them
+	remote, desc := suite.pushTestManifest(url, platforms[0], "multi updated")
+	suite.NoError(remote.UpdateIndex(ctx, "platforms", desc, WithIndexArtifactType("application/vnd.example.package"),
+		WithIndexAnnotations(map[string]string{ocispec.AnnotationDescription: "multi-platform"})))
+	remote, desc = suite.pushTestManifest(url, platforms[1], "linux/arm64 updated")
+	suite.NoError(remote.UpdateIndex(ctx, "platforms", desc, WithIndexAnnotations(map[string]string{ocispec.AnnotationVendor: "Defense Unicorns"})))
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	indexDesc, err := remote.Repo().Resolve(ctx, "platforms")
+	suite.NoError(err)
+	b, err := content.FetchAll(ctx, remote.Repo(), indexDesc)
+	suite.NoError(err)
+	var index ocispec.Index
+	suite.NoError(json.Unmarshal(b, &index))
+	suite.Len(index.Manifests, len(platforms))
+	suite.Equal(desc.Digest, index.Manifests[1].Digest)
+	suite.Equal("application/vnd.example.package", index.ArtifactType)
+	suite.Equal(map[string]string{ocispec.AnnotationDescription: "multi-platform", ocispec.AnnotationVendor: "Defense Unicorns"}, index.Annotations)
+
+	err = remote.RemoveFromIndex(ctx, "platforms", ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	suite.ErrorIs(err, errdef.ErrNotFound)
+	err = remote.RemoveFromIndex(ctx, "missing", platforms[0])
+	suite.ErrorIs(err, errdef.ErrNotFound)
+
+	for idx, platform := range platforms {
+		suite.NoError(remote.RemoveFromIndex(ctx, "platforms", platform))
+		index, base, err := remote.fetchIndex(ctx, "platforms")
+		suite.NoError(err)
+		suite.Len(index.Manifests, len(platforms)-idx-1)
+		if idx == len(platforms)-1 {
+			// the index is deleted with its last entry
+			suite.Empty(base.Digest)
+		}
+	}
+}
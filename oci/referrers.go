@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"fmt"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+)
+
+// PackOption is a function that modifies the options used by PackAndTagManifest
+type PackOption func(*oras.PackManifestOptions)
+
+// WithSubject sets the subject of the packed manifest, making it a referrer of subject.
+func WithSubject(subject ocispec.Descriptor) PackOption {
+	return func(opts *oras.PackManifestOptions) {
+		opts.Subject = &subject
+	}
+}
+
+// PushReferrer pushes an artifact of artifactType whose subject is the root manifest, and returns its descriptor.
+//
+// The layers must already be pushed to the remote repository, see PushLayer. The artifact is pushed by digest only,
+// registries without the referrers API are handled by updating the referrers tag of the root manifest.
+func (o *OrasRemote) PushReferrer(ctx context.Context, artifactType string, layers []ocispec.Descriptor, annotations map[string]string) (ocispec.Descriptor, error) {
+	if artifactType == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("invalid referrer: artifact type must be set")
+	}
+	ctx = withoutMirrors(ctx)
+	subject, err := o.ResolveRoot(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	packOpts := oras.PackManifestOptions{
+		Subject:             &subject,
+		Layers:              layers,
+		ManifestAnnotations: annotations,
+	}
+	desc, err := oras.PackManifest(ctx, o.repo, oras.PackManifestVersion1_1, artifactType, packOpts)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to push referrer of %s: %w", subject.Digest, err)
+	}
+	return desc, nil
+}
+
+// Referrers lists the artifacts whose subject is the root manifest, limited to artifactType if it is not empty.
+//
+// The referrers API is used when the registry supports it, otherwise the referrers tag of the root manifest is read.
+func (o *OrasRemote) Referrers(ctx context.Context, artifactType string) ([]ocispec.Descriptor, error) {
+	subject, err := o.ResolveRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return o.referrersOf(ctx, subject, artifactType)
+}
+
+// FetchReferrers fetches the manifests of the artifacts whose subject is the root manifest, limited to artifactType
+// if it is not empty. The layers of each referrer can be fetched with FetchLayer.
+func (o *OrasRemote) FetchReferrers(ctx context.Context, artifactType string) ([]*Manifest, error) {
+	referrers, err := o.Referrers(ctx, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]*Manifest, 0, len(referrers))
+	for _, referrer := range referrers {
+		manifest, err := o.FetchManifest(ctx, referrer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch referrer %s: %w", referrer.Digest, err)
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// referrersOf lists the artifacts whose subject is subject, limited to artifactType if it is not empty.
+func (o *OrasRemote) referrersOf(ctx context.Context, subject ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	var referrers []ocispec.Descriptor
+	err := o.repo.Referrers(ctx, subject, artifactType, func(page []ocispec.Descriptor) error {
+		referrers = append(referrers, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s: %w", subject.Digest, err)
+	}
+	return referrers, nil
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/file"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+const (
+	sbomArtifactType = "application/spdx+json"
+	scanArtifactType = "application/vnd.example.scan+json"
+)
+
+func (suite *OCISuite) TestReferrers() {
+	for _, detectCapability := range []bool{true, false} {
+		ctx := context.TODO()
+		srcTempDir := suite.T().TempDir()
+		path := filepath.Join(srcTempDir, "subject-file")
+		// a distinct subject for each case so referrers don't accumulate
+		suite.NoError(os.WriteFile(path, []byte(fmt.Sprintf("subject %t", detectCapability)), helpers.ReadWriteUser))
+		src, err := file.New(srcTempDir)
+		suite.NoError(err)
+		desc, err := src.Add(ctx, "subject-file", ocispec.MediaTypeImageLayer, path)
+		suite.NoError(err)
+		suite.publishPackage(src, []ocispec.Descriptor{desc})
+
+		remote, err := NewOrasRemote("oci://"+suite.remote.Repo().Reference.String(), PlatformForArch(testArch), WithPlainHTTP(true))
+		suite.NoError(err)
+		if !detectCapability {
+			// skip detection and use the referrers tag schema of registries without the referrers API
+			suite.NoError(remote.Repo().SetReferrersCapability(false))
+		}
+		subject, err := remote.ResolveRoot(ctx)
+		suite.NoError(err)
+
+		sbom, err := remote.PushLayer(ctx, []byte(`{"spdxVersion":"SPDX-2.3"}`), sbomArtifactType)
+		suite.NoError(err)
+		sbom.Annotations = map[string]string{ocispec.AnnotationTitle: "sbom.spdx.json"}
+		sbomDesc, err := remote.PushReferrer(ctx, sbomArtifactType, []ocispec.Descriptor{*sbom}, nil)
+		suite.NoError(err)
+		_, err = remote.PushReferrer(ctx, scanArtifactType, nil, nil)
+		suite.NoError(err)
+		_, err = remote.PushReferrer(ctx, "", nil, nil)
+		suite.ErrorContains(err, "artifact type must be set")
+
+		referrers, err := remote.Referrers(ctx, "")
+		suite.NoError(err)
+		suite.Len(referrers, 2)
+
+		referrers, err = remote.Referrers(ctx, sbomArtifactType)
+		suite.NoError(err)
+		suite.Len(referrers, 1)
+		suite.Equal(sbomDesc.Digest, referrers[0].Digest)
+		suite.Equal(sbomArtifactType, referrers[0].ArtifactType)
+
+		manifests, err := remote.FetchReferrers(ctx, sbomArtifactType)
+		suite.NoError(err)
+		suite.Len(manifests, 1)
+		suite.Equal(subject.Digest, manifests[0].Subject.Digest)
+		b, err := remote.FetchLayer(ctx, manifests[0].Locate("sbom.spdx.json"))
+		suite.NoError(err)
+		suite.Equal(`{"spdxVersion":"SPDX-2.3"}`, string(b))
+
+		// PackAndTagManifest can attach a manifest to a subject
+		configDesc, err := remote.CreateAndPushManifestConfig(ctx, map[string]string{ocispec.AnnotationTitle: "attached"}, scanArtifactType)
+		suite.NoError(err)
+		attached, err := remote.PackAndTagManifest(ctx, src, []ocispec.Descriptor{desc}, configDesc, nil, WithSubject(subject))
+		suite.NoError(err)
+		_, err = oras.Copy(ctx, src, attached.Digest.String(), remote.Repo(), "", remote.GetDefaultCopyOpts())
+		suite.NoError(err)
+		referrers, err = remote.Referrers(ctx, scanArtifactType)
+		suite.NoError(err)
+		suite.Len(referrers, 2)
+	}
+}
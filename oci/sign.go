@@ -0,0 +1,411 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+const (
+	// SimpleSigningArtifactType is the artifact type of cosign signatures
+	SimpleSigningArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// SimpleSigningMediaType is the media type of cosign simple signing payloads
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SimpleSigningSignatureAnnotation is the annotation on a simple signing payload holding its base64 encoded signature
+	SimpleSigningSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// JWSArtifactType is the artifact type of JWS signatures
+	JWSArtifactType = "application/vnd.defenseunicorns.signature.jws"
+	// JWSMediaType is the media type of JWS signature envelopes
+	JWSMediaType = "application/jose+json"
+	// JWSPayloadContentType is the content type of the payload of JWS signature envelopes
+	JWSPayloadContentType = "application/vnd.defenseunicorns.signature.payload.v1+json"
+
+	// simpleSigningType is the type of a cosign container image signature payload
+	simpleSigningType = "cosign container image signature"
+	// signatureTagSuffix is appended to the digest of the signed manifest to form its signature tag
+	signatureTagSuffix = ".sig"
+)
+
+// SignatureFormat is the format a signature is written in.
+type SignatureFormat string
+
+const (
+	// SignatureFormatSimpleSigning signs a cosign compatible simple signing payload
+	SignatureFormatSimpleSigning SignatureFormat = "simplesigning"
+	// SignatureFormatJWS signs a JWS envelope specific to this package, signed by a bare key rather than an X.509
+	// certificate chain, so it can't be verified by Notation
+	SignatureFormatJWS SignatureFormat = "jws"
+)
+
+// SignatureStorage is where a signature is stored in the registry.
+type SignatureStorage string
+
+const (
+	// SignatureStorageReferrer stores the signature as a referrer of the signed manifest
+	SignatureStorageReferrer SignatureStorage = "referrer"
+	// SignatureStorageTag stores the signature under the `sha256-<digest>.sig` tag of the signed manifest
+	SignatureStorageTag SignatureStorage = "tag"
+)
+
+// Signer signs manifests with a local key.
+type Signer struct {
+	// Key is an ECDSA or ED25519 private key, see LoadSigningKey
+	Key crypto.Signer
+	// Format is the format of the signature, defaults to SignatureFormatSimpleSigning
+	Format SignatureFormat
+	// Storage is where the signature is stored, defaults to SignatureStorageReferrer
+	Storage SignatureStorage
+}
+
+// WithSigner signs every manifest added to an index by UpdateIndex with signer.
+func WithSigner(signer Signer) Modifier {
+	return func(o *OrasRemote) {
+		o.signer = &signer
+	}
+}
+
+// LoadSigningKey reads an unencrypted PEM encoded ECDSA or ED25519 private key from the file at path.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key in %s: %w", path, err)
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s, only ECDSA and ED25519 keys are supported", key, path)
+	}
+}
+
+// Sign signs the manifest described by desc, which must already be pushed to the remote repository,
+// and stores the signature as configured by signer. The descriptor of the signature manifest is returned.
+func (o *OrasRemote) Sign(ctx context.Context, desc ocispec.Descriptor, signer Signer) (ocispec.Descriptor, error) {
+	ctx = withoutMirrors(ctx)
+	var (
+		artifactType string
+		layer        ocispec.Descriptor
+		layerBytes   []byte
+		err          error
+	)
+	switch signer.Format {
+	case SignatureFormatSimpleSigning, "":
+		artifactType = SimpleSigningArtifactType
+		layer, layerBytes, err = o.simpleSigningLayer(desc, signer.Key)
+	case SignatureFormatJWS:
+		artifactType = JWSArtifactType
+		layer, layerBytes, err = jwsLayer(desc, signer.Key)
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported signature format %q", signer.Format)
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to sign %s: %w", desc.Digest, err)
+	}
+	if err := o.pushBlob(ctx, layer, layerBytes); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	switch signer.Storage {
+	case SignatureStorageReferrer, "":
+		packOpts := oras.PackManifestOptions{
+			Subject: &desc,
+			Layers:  []ocispec.Descriptor{layer},
+		}
+		sigDesc, err := oras.PackManifest(ctx, o.repo, oras.PackManifestVersion1_1, artifactType, packOpts)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to push signature of %s: %w", desc.Digest, err)
+		}
+		o.logSignature(desc, sigDesc, signer)
+		return sigDesc, nil
+	case SignatureStorageTag:
+		sigDesc, err := o.pushSignatureTag(ctx, desc, artifactType, layer)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("failed to push signature of %s: %w", desc.Digest, err)
+		}
+		o.logSignature(desc, sigDesc, signer)
+		return sigDesc, nil
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported signature storage %q", signer.Storage)
+	}
+}
+
+// signatureTag returns the tag signatures of the manifest described by desc are stored under.
+func signatureTag(desc ocispec.Descriptor) string {
+	return strings.Replace(desc.Digest.String(), ":", "-", 1) + signatureTagSuffix
+}
+
+// pushSignatureTag adds the signature layer to the manifest at the signature tag of desc, keeping existing signatures.
+func (o *OrasRemote) pushSignatureTag(ctx context.Context, desc ocispec.Descriptor, artifactType string, layer ocispec.Descriptor) (ocispec.Descriptor, error) {
+	tag := signatureTag(desc)
+	layers := []ocispec.Descriptor{layer}
+	existing, rc, err := o.repo.FetchReference(ctx, tag)
+	switch {
+	case err == nil:
+		b, err := content.ReadAll(rc, existing)
+		rc.Close()
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		if manifest.ArtifactType == artifactType {
+			if slices.ContainsFunc(manifest.Layers, func(l ocispec.Descriptor) bool { return l.Digest == layer.Digest }) {
+				return existing, nil
+			}
+			layers = append(manifest.Layers, layer)
+		}
+	case !errors.Is(err, errdef.ErrNotFound):
+		return ocispec.Descriptor{}, err
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers:       layers,
+	}
+	if err := o.pushBlob(ctx, ocispec.DescriptorEmptyJSON, ocispec.DescriptorEmptyJSON.Data); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	manifestDesc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, b)
+	manifestDesc.ArtifactType = artifactType
+	if err := o.repo.PushReference(ctx, manifestDesc, bytes.NewReader(b), tag); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return manifestDesc, nil
+}
+
+// pushBlob pushes b to the remote repository unless it already exists.
+func (o *OrasRemote) pushBlob(ctx context.Context, desc ocispec.Descriptor, b []byte) error {
+	exists, err := o.repo.Exists(ctx, desc)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return o.repo.Push(ctx, desc, bytes.NewReader(b))
+}
+
+// logSignature logs where the signature of desc was stored.
+func (o *OrasRemote) logSignature(desc, sigDesc ocispec.Descriptor, signer Signer) {
+	if o.log != nil {
+		o.log.Debug("signed manifest", "digest", desc.Digest, "signature", sigDesc.Digest,
+			"format", string(signer.Format), "storage", string(signer.Storage))
+	}
+}
+
+// simpleSigningPayload is a cosign simple signing payload.
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]any `json:"optional"`
+}
+
+// simpleSigningIdentity returns the docker reference that simple signing payloads of the remote repository are bound to.
+func (o *OrasRemote) simpleSigningIdentity() string {
+	return o.repo.Reference.Registry + "/" + o.repo.Reference.Repository
+}
+
+// simpleSigningLayer returns the simple signing payload layer signing desc, with its signature as an annotation.
+func (o *OrasRemote) simpleSigningLayer(desc ocispec.Descriptor, key crypto.Signer) (ocispec.Descriptor, []byte, error) {
+	var payload simpleSigningPayload
+	payload.Critical.Identity.DockerReference = o.simpleSigningIdentity()
+	payload.Critical.Image.DockerManifestDigest = desc.Digest.String()
+	payload.Critical.Type = simpleSigningType
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	sig, err := signPayload(key, b, false)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	layer := content.NewDescriptorFromBytes(SimpleSigningMediaType, b)
+	layer.Annotations = map[string]string{
+		SimpleSigningSignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+	}
+	return layer, b, nil
+}
+
+// jwsHeader is the protected header of a JWS envelope.
+type jwsHeader struct {
+	Algorithm   string `json:"alg"`
+	ContentType string `json:"cty"`
+	SigningTime string `json:"signingTime"`
+}
+
+// jwsPayload is the payload of a JWS envelope.
+type jwsPayload struct {
+	TargetArtifact ocispec.Descriptor `json:"targetArtifact"`
+}
+
+// jwsEnvelope is a JWS envelope in the flattened JSON serialization.
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Signature string `json:"signature"`
+}
+
+// jwsLayer returns a JWS envelope layer signing desc.
+func jwsLayer(desc ocispec.Descriptor, key crypto.Signer) (ocispec.Descriptor, []byte, error) {
+	alg, err := jwsAlgorithm(key.Public())
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	header, err := json.Marshal(jwsHeader{
+		Algorithm:   alg,
+		ContentType: JWSPayloadContentType,
+		SigningTime: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	payload, err := json.Marshal(jwsPayload{
+		TargetArtifact: ocispec.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+
+	envelope := jwsEnvelope{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Protected: base64.RawURLEncoding.EncodeToString(header),
+	}
+	sig, err := signPayload(key, []byte(envelope.Protected+"."+envelope.Payload), true)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	envelope.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	return content.NewDescriptorFromBytes(JWSMediaType, b), b, nil
+}
+
+// jwsAlgorithm returns the JWS algorithm for the public key.
+func jwsAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported ECDSA curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T, only ECDSA and ED25519 keys are supported", pub)
+	}
+}
+
+// ecdsaHash returns the hash used with the ECDSA curve.
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve {
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+// signPayload signs b with key.
+//
+// ECDSA signatures are ASN.1 encoded unless raw is set, in which case they are the fixed size `r || s` used by JWS.
+func signPayload(key crypto.Signer, b []byte, raw bool) ([]byte, error) {
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		hash := ecdsaHash(pub.Curve)
+		h := hash.New()
+		h.Write(b)
+		sig, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+		if err != nil || !raw {
+			return sig, err
+		}
+		var parsed struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+			return nil, err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		rawSig := make([]byte, 2*size)
+		parsed.R.FillBytes(rawSig[:size])
+		parsed.S.FillBytes(rawSig[size:])
+		return rawSig, nil
+	case ed25519.PublicKey:
+		return key.Sign(rand.Reader, b, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only ECDSA and ED25519 keys are supported", pub)
+	}
+}
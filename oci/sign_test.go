@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/errdef"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+// writeSigningKey writes key to a PEM file in a temporary directory and returns its path.
+func writeSigningKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "signing.key")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), helpers.ReadWriteUser))
+	return path
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	loaded, err := LoadSigningKey(writeSigningKey(t, ecKey))
+	require.NoError(t, err)
+	require.True(t, ecKey.Equal(loaded))
+
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	ecPath := filepath.Join(t.TempDir(), "ec.key")
+	require.NoError(t, os.WriteFile(ecPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), helpers.ReadWriteUser))
+	loaded, err = LoadSigningKey(ecPath)
+	require.NoError(t, err)
+	require.True(t, ecKey.Equal(loaded))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	loaded, err = LoadSigningKey(writeSigningKey(t, edKey))
+	require.NoError(t, err)
+	require.True(t, edKey.Equal(loaded))
+
+	notKey := filepath.Join(t.TempDir(), "not.key")
+	require.NoError(t, os.WriteFile(notKey, []byte("not a key"), helpers.ReadWriteUser))
+	_, err = LoadSigningKey(notKey)
+	require.ErrorContains(t, err, "no PEM encoded key")
+}
+
+func (suite *OCISuite) TestSign() {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.NoError(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	suite.NoError(err)
+
+	tests := []struct {
+		name   string
+		signer Signer
+	}{
+		{name: "simple signing referrer", signer: Signer{Key: ecKey}},
+		{name: "simple signing tag", signer: Signer{Key: edKey, Storage: SignatureStorageTag}},
+		{name: "jws referrer", signer: Signer{Key: edKey, Format: SignatureFormatJWS}},
+		{name: "jws tag", signer: Signer{Key: ecKey, Format: SignatureFormatJWS, Storage: SignatureStorageTag}},
+	}
+	for _, tt := range tests {
+		ctx := context.TODO()
+		remote, desc := suite.pushTestManifest("oci://"+suite.remote.Repo().Reference.String(), PlatformForArch(testArch),
+			tt.name, WithSigner(tt.signer))
+		suite.NoError(remote.UpdateIndex(ctx, "0.0.1", desc))
+		root, err := remote.ResolveRoot(ctx)
+		suite.NoError(err)
+
+		var sigManifest *Manifest
+		if tt.signer.Storage == SignatureStorageTag {
+			sigDesc, err := remote.Repo().Resolve(ctx, signatureTag(root))
+			suite.NoError(err, tt.name)
+			sigManifest, err = remote.FetchManifest(ctx, sigDesc)
+			suite.NoError(err)
+		} else {
+			artifactType := SimpleSigningArtifactType
+			if tt.signer.Format == SignatureFormatJWS {
+				artifactType = JWSArtifactType
+			}
+			manifests, err := remote.FetchReferrers(ctx, artifactType)
+			suite.NoError(err)
+			suite.Len(manifests, 1, tt.name)
+			sigManifest = manifests[0]
+		}
+		suite.Len(sigManifest.Layers, 1)
+		layer := sigManifest.Layers[0]
+		b, err := remote.FetchLayer(ctx, layer)
+		suite.NoError(err)
+
+		if tt.signer.Format == SignatureFormatJWS {
+			var envelope jwsEnvelope
+			suite.NoError(json.Unmarshal(b, &envelope))
+			sig, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+			suite.NoError(err)
+			suite.True(verifyTestSignature(tt.signer.Key.Public(), []byte(envelope.Protected+"."+envelope.Payload), sig, true), tt.name)
+			// the envelope has no certificate chain, so it must not claim a Notary Project signing scheme
+			header, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+			suite.NoError(err)
+			var fields map[string]any
+			suite.NoError(json.Unmarshal(header, &fields))
+			suite.Equal(JWSPayloadContentType, fields["cty"])
+			suite.NotContains(fields, "crit")
+			suite.NotContains(fields, "io.cncf.notary.signingScheme")
+			payload, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+			suite.NoError(err)
+			suite.Contains(string(payload), root.Digest.String())
+			continue
+		}
+		var payload simpleSigningPayload
+		suite.NoError(json.Unmarshal(b, &payload))
+		suite.Equal(root.Digest.String(), payload.Critical.Image.DockerManifestDigest)
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations[SimpleSigningSignatureAnnotation])
+		suite.NoError(err)
+		suite.True(verifyTestSignature(tt.signer.Key.Public(), b, sig, false), tt.name)
+	}
+}
+
+// verifyTestSignature checks a signature made by signPayload.
+func verifyTestSignature(pub crypto.PublicKey, b, sig []byte, raw bool) bool {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(b)
+		if raw {
+			r := new(big.Int).SetBytes(sig[:len(sig)/2])
+			s := new(big.Int).SetBytes(sig[len(sig)/2:])
+			return ecdsa.Verify(pub, digest[:], r, s)
+		}
+		return ecdsa.VerifyASN1(pub, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, b, sig)
+	default:
+		panic(fmt.Sprintf("unexpected key type %T", pub))
+	}
+}
+
+func (suite *OCISuite) TestUpdateIndexSignsFirst() {
+	ctx := context.TODO()
+	url := suite.setupInMemoryRegistry(ctx)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.NoError(err)
+	_, desc := suite.pushTestManifest(url, PlatformForArch(testArch), "unsigned")
+
+	// the tag is not created when signing fails
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithSigner(Signer{Key: ecKey, Format: "unknown"}))
+	suite.NoError(err)
+	err = remote.UpdateIndex(ctx, "1.0.1", desc)
+	suite.ErrorContains(err, "unsupported signature format")
+	_, err = remote.Repo().Resolve(ctx, "1.0.1")
+	suite.ErrorIs(err, errdef.ErrNotFound)
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+)
+
+// ErrNoMatchingVersion is returned when no tag satisfies a version constraint
+var ErrNoMatchingVersion = errors.New("no tag satisfies the version constraint")
+
+// artifactTagSuffixes are the suffixes of tags holding signatures, attestations and SBOMs attached to other tags
+var artifactTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// WithTagPageSize sets the number of tags requested per page when listing tags
+func WithTagPageSize(size int) Modifier {
+	return func(o *OrasRemote) {
+		o.repo.TagListPageSize = size
+	}
+}
+
+// ListTags lists the tags of the remote repository in pages, calling fn with each page.
+//
+// Listing starts after last, or from the first tag if last is empty. Returning an error from fn stops the listing.
+func (o *OrasRemote) ListTags(ctx context.Context, last string, fn func(tags []string) error) error {
+	if err := o.repo.Tags(ctx, last, fn); err != nil {
+		return fmt.Errorf("failed to list tags of %s: %w", o.repo.Reference.Repository, err)
+	}
+	return nil
+}
+
+// Tags returns all the tags of the remote repository.
+func (o *OrasRemote) Tags(ctx context.Context) ([]string, error) {
+	var tags []string
+	err := o.ListTags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	return tags, err
+}
+
+// ResolveVersion returns the highest tag of the remote repository satisfying constraint, e.g. `~1.4` or `>=2.0.0-0`.
+//
+// Tags that are not semantic versions, as well as signature and attestation tags, are ignored. When requirePlatform
+// is true, tags without a manifest for the target platform are skipped.
+func (o *OrasRemote) ResolveVersion(ctx context.Context, constraint string, requirePlatform bool) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+
+	type candidate struct {
+		tag     string
+		version *semver.Version
+	}
+	var candidates []candidate
+	err = o.ListTags(ctx, "", func(tags []string) error {
+		for _, tag := range tags {
+			if slices.ContainsFunc(artifactTagSuffixes, func(suffix string) bool {
+				return strings.HasSuffix(tag, suffix)
+			}) {
+				continue
+			}
+			v, err := semver.NewVersion(tag)
+			if err != nil || !c.Check(v) {
+				continue
+			}
+			candidates = append(candidates, candidate{tag, v})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return b.version.Compare(a.version)
+	})
+
+	for _, candidate := range candidates {
+		if !requirePlatform {
+			return candidate.tag, nil
+		}
+		ok, err := o.hasPlatform(ctx, candidate.tag)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return candidate.tag, nil
+		}
+		if o.log != nil {
+			o.log.Debug("skipping tag without a manifest for the target platform", "tag", candidate.tag, "platform", platformString(*o.targetPlatform))
+		}
+	}
+	return "", fmt.Errorf("%s %q: %w", o.repo.Reference.Repository, constraint, ErrNoMatchingVersion)
+}
+
+// hasPlatform returns true if tag is an index with a manifest for the target platform,
+// or a manifest whose config architecture is the target architecture.
+func (o *OrasRemote) hasPlatform(ctx context.Context, tag string) (bool, error) {
+	desc, err := o.repo.Resolve(ctx, tag)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve %s: %w", tag, err)
+	}
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		index, err := FetchUnmarshal[ocispec.Index](ctx, o.repo, json.Unmarshal, desc)
+		if err != nil {
+			return false, err
+		}
+		return slices.ContainsFunc(index.Manifests, func(manifest ocispec.Descriptor) bool {
+			return platformMatches(*o.targetPlatform, manifest.Platform)
+		}), nil
+	}
+	manifest, err := FetchUnmarshal[ocispec.Manifest](ctx, o.repo, json.Unmarshal, desc)
+	if err != nil {
+		return false, err
+	}
+	b, err := content.FetchAll(ctx, o.repo, manifest.Config)
+	if err != nil {
+		return false, err
+	}
+	var config ConfigPartial
+	if err := json.Unmarshal(b, &config); err != nil {
+		return false, nil
+	}
+	return config.Architecture == o.targetPlatform.Architecture, nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import "context"
+
+func (suite *OCISuite) TestResolveVersion() {
+	ctx := context.TODO()
+	url := suite.setupInMemoryRegistry(ctx)
+
+	for _, tag := range []string{"1.3.0", "1.4.2", "2.0.0-rc.1", "latest"} {
+		remote, desc := suite.pushTestManifest(url, PlatformForArch(testArch), tag)
+		suite.NoError(remote.UpdateIndex(ctx, tag, desc))
+	}
+	// a manifest tagged directly instead of through an index
+	remote, desc := suite.pushTestManifest(url, PlatformForArch(testArch), "1.4.5")
+	suite.NoError(remote.Repo().Tag(ctx, desc, "1.4.5"))
+	sigTag := signatureTag(desc)
+	suite.NoError(remote.Repo().Tag(ctx, desc, sigTag))
+	remote, desc = suite.pushTestManifest(url, PlatformForArch("other-arch"), "1.4.9")
+	suite.NoError(remote.UpdateIndex(ctx, "1.4.9", desc))
+
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true), WithTagPageSize(2))
+	suite.NoError(err)
+	pages := 0
+	var tags []string
+	err = remote.ListTags(ctx, "", func(page []string) error {
+		pages++
+		suite.LessOrEqual(len(page), 2)
+		tags = append(tags, page...)
+		return nil
+	})
+	suite.NoError(err)
+	suite.Equal(4, pages)
+	all, err := remote.Tags(ctx)
+	suite.NoError(err)
+	suite.Equal(tags, all)
+	suite.ElementsMatch([]string{"1.3.0", "1.4.2", "1.4.5", "1.4.9", "2.0.0-rc.1", "latest", sigTag}, all)
+
+	tests := []struct {
+		constraint      string
+		requirePlatform bool
+		expected        string
+	}{
+		{constraint: "~1.4", expected: "1.4.9"},
+		{constraint: "~1.4", requirePlatform: true, expected: "1.4.5"},
+		{constraint: "<1.4.5", requirePlatform: true, expected: "1.4.2"},
+		{constraint: ">=1.0.0", expected: "1.4.9"},
+		{constraint: ">=2.0.0-0", expected: "2.0.0-rc.1"},
+	}
+	for _, tt := range tests {
+		tag, err := remote.ResolveVersion(ctx, tt.constraint, tt.requirePlatform)
+		suite.NoError(err, tt.constraint)
+		suite.Equal(tt.expected, tag, tt.constraint)
+	}
+
+	_, err = remote.ResolveVersion(ctx, ">=2.0.0", false)
+	suite.ErrorIs(err, ErrNoMatchingVersion)
+	_, err = remote.ResolveVersion(ctx, "~1.4.9", true)
+	suite.ErrorIs(err, ErrNoMatchingVersion)
+	_, err = remote.ResolveVersion(ctx, "not a constraint", false)
+	suite.ErrorContains(err, "invalid version constraint")
+}
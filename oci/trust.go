@@ -0,0 +1,356 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// ErrUntrusted is wrapped by every error returned when an artifact does not satisfy the trust policy
+var ErrUntrusted = errors.New("artifact is not trusted")
+
+// UnsignedError is returned when an artifact covered by the trust policy has no signatures.
+type UnsignedError struct {
+	Reference string
+	Digest    digest.Digest
+}
+
+// Error returns the error message.
+func (e *UnsignedError) Error() string {
+	return fmt.Sprintf("%s (%s) is not signed", e.Reference, e.Digest)
+}
+
+// Unwrap returns ErrUntrusted.
+func (e *UnsignedError) Unwrap() error {
+	return ErrUntrusted
+}
+
+// UntrustedSignatureError is returned when none of the signatures of an artifact covered by the trust policy
+// are valid signatures made by one of the trusted keys.
+type UntrustedSignatureError struct {
+	Reference string
+	Digest    digest.Digest
+	// Signatures is the number of signatures found
+	Signatures int
+}
+
+// Error returns the error message.
+func (e *UntrustedSignatureError) Error() string {
+	return fmt.Sprintf("none of the %d signature(s) of %s (%s) were made by a trusted key", e.Signatures, e.Reference, e.Digest)
+}
+
+// Unwrap returns ErrUntrusted.
+func (e *UntrustedSignatureError) Unwrap() error {
+	return ErrUntrusted
+}
+
+// TrustRule trusts the given keys for the repositories matching Pattern.
+type TrustRule struct {
+	// Pattern is matched against `registry/repository` with path.Match, e.g. `ghcr.io/defenseunicorns/packages/*`
+	Pattern string
+	// Keys are the ECDSA or ED25519 public keys trusted to sign the matching repositories, see LoadVerificationKey
+	Keys []crypto.PublicKey
+}
+
+// TrustPolicy lists the keys trusted to sign artifacts by repository.
+//
+// The most specific (longest) matching pattern applies, repositories that match no rule are not verified
+// unless DenyUnmatched is set.
+type TrustPolicy struct {
+	Rules []TrustRule
+	// DenyUnmatched refuses artifacts from repositories that match no rule instead of not verifying them
+	DenyUnmatched bool
+	// AuditOnly logs artifacts that fail verification instead of refusing them
+	AuditOnly bool
+}
+
+// WithTrustPolicy verifies the signature of the root manifest on FetchRoot (and so PullPaths and Copy)
+// against policy before any layers are fetched.
+//
+// Signatures created by Sign are listed in the registry, either as referrers or from the signature tag, and read
+// through the layer cache when one is configured. With WithPreferCache the signature that was verified is recorded in
+// the cache, so the artifact can be verified again with WithOffline.
+//
+// Simple signing signatures are only accepted in the registry and repository they were signed for, while JWS
+// signatures are bound to the digest alone.
+func WithTrustPolicy(policy TrustPolicy) Modifier {
+	return func(o *OrasRemote) {
+		o.trustPolicy = &policy
+	}
+}
+
+// LoadVerificationKey reads a PEM encoded ECDSA or ED25519 public key from the file at path.
+func LoadVerificationKey(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key found in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key in %s: %w", path, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s, only ECDSA and ED25519 keys are supported", key, path)
+	}
+}
+
+// trustedKeys returns the keys trusted for the remote's repository, and false if no rule matches it.
+func (o *OrasRemote) trustedKeys() ([]crypto.PublicKey, bool) {
+	if o.trustPolicy == nil {
+		return nil, false
+	}
+	name := o.repo.Reference.Registry + "/" + o.repo.Reference.Repository
+	var match *TrustRule
+	for idx, rule := range o.trustPolicy.Rules {
+		if ok, _ := path.Match(rule.Pattern, name); !ok {
+			continue
+		}
+		if match == nil || len(rule.Pattern) > len(match.Pattern) {
+			match = &o.trustPolicy.Rules[idx]
+		}
+	}
+	if match == nil {
+		return nil, false
+	}
+	return match.Keys, true
+}
+
+// verifyTrust verifies the manifest described by desc is signed by a key trusted for the remote's repository.
+func (o *OrasRemote) verifyTrust(ctx context.Context, desc ocispec.Descriptor) error {
+	if o.trustPolicy == nil {
+		return nil
+	}
+	var err error
+	if keys, ok := o.trustedKeys(); ok {
+		err = o.verifySignatures(ctx, desc, keys)
+	} else if o.trustPolicy.DenyUnmatched {
+		err = fmt.Errorf("no trust rule matches %s/%s: %w", o.repo.Reference.Registry, o.repo.Reference.Repository, ErrUntrusted)
+	} else {
+		return nil
+	}
+	if err == nil {
+		if o.log != nil {
+			o.log.Debug("verified signature", "reference", o.repo.Reference.String(), "digest", desc.Digest)
+		}
+		return nil
+	}
+	if o.trustPolicy.AuditOnly && errors.Is(err, ErrUntrusted) {
+		if o.log != nil {
+			o.log.Warn("artifact failed signature verification", "reference", o.repo.Reference.String(), "digest", desc.Digest, "error", err.Error())
+		}
+		return nil
+	}
+	return err
+}
+
+// verifySignatures checks for a valid signature of desc made by one of keys.
+//
+// Signatures that fail to fetch or parse are skipped, the artifact is only refused once every signature was tried.
+func (o *OrasRemote) verifySignatures(ctx context.Context, desc ocispec.Descriptor, keys []crypto.PublicKey) error {
+	signatures, err := o.signatureDescriptors(ctx, desc)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, sigDesc := range signatures {
+		manifest, err := FetchUnmarshal[*Manifest](ctx, o, json.Unmarshal, sigDesc)
+		if err != nil {
+			count++
+			o.logSkippedSignature(desc, sigDesc, err)
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != SimpleSigningMediaType && layer.MediaType != JWSMediaType {
+				continue
+			}
+			count++
+			b, err := content.FetchAll(ctx, o, layer)
+			if err != nil {
+				o.logSkippedSignature(desc, sigDesc, err)
+				continue
+			}
+			if slices.ContainsFunc(keys, func(key crypto.PublicKey) bool {
+				if layer.MediaType == JWSMediaType {
+					return verifyJWS(b, desc, key)
+				}
+				return verifySimpleSigning(b, layer.Annotations[SimpleSigningSignatureAnnotation], desc, o.simpleSigningIdentity(), key)
+			}) {
+				o.recordSignature(ctx, desc, sigDesc)
+				return nil
+			}
+		}
+	}
+	if count == 0 {
+		return &UnsignedError{Reference: o.repo.Reference.String(), Digest: desc.Digest}
+	}
+	return &UntrustedSignatureError{Reference: o.repo.Reference.String(), Digest: desc.Digest, Signatures: count}
+}
+
+// logSkippedSignature logs a signature of desc that could not be read.
+func (o *OrasRemote) logSkippedSignature(desc, sigDesc ocispec.Descriptor, err error) {
+	if o.log != nil {
+		o.log.Warn("skipping unreadable signature", "digest", desc.Digest, "signature", sigDesc.Digest, "error", err.Error())
+	}
+}
+
+// signatureDescriptors lists the signature manifests of desc stored as referrers or under its signature tag.
+//
+// When offline only the signature recorded in the cache by recordSignature is listed.
+func (o *OrasRemote) signatureDescriptors(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	if o.cacheMode == cacheModeOffline {
+		sigDesc, err := o.src().Resolve(ctx, o.signatureKey(desc))
+		if err != nil {
+			return nil, err
+		}
+		return []ocispec.Descriptor{sigDesc}, nil
+	}
+
+	var descs []ocispec.Descriptor
+	for _, artifactType := range []string{SimpleSigningArtifactType, JWSArtifactType} {
+		referrers, err := o.referrersOf(ctx, desc, artifactType)
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, referrers...)
+	}
+
+	sigDesc, err := o.repo.Resolve(ctx, signatureTag(desc))
+	if errors.Is(err, errdef.ErrNotFound) {
+		return descs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(descs, sigDesc), nil
+}
+
+// signatureKey returns the reference the verified signature of desc is tagged with in the cache.
+func (o *OrasRemote) signatureKey(desc ocispec.Descriptor) string {
+	return o.repo.Reference.Registry + "/" + o.repo.Reference.Repository + ":" + signatureTag(desc)
+}
+
+// recordSignature records the verified signature of desc in the cache when preferring the cache, so it can be
+// verified again offline.
+func (o *OrasRemote) recordSignature(ctx context.Context, desc, sigDesc ocispec.Descriptor) {
+	if o.cache == nil || o.cacheMode != cacheModePreferCache {
+		return
+	}
+	// the signature manifest was cached when it was fetched
+	if err := o.cache.Tag(ctx, sigDesc, o.signatureKey(desc)); err != nil && o.log != nil {
+		o.log.Warn("unable to record signature in the cache", "reference", o.signatureKey(desc), "error", err.Error())
+	}
+}
+
+// verifySimpleSigning verifies a simple signing payload signs desc as part of the repository identity with key.
+func verifySimpleSigning(payload []byte, signature string, desc ocispec.Descriptor, identity string, key crypto.PublicKey) bool {
+	var p simpleSigningPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return false
+	}
+	if p.Critical.Type != simpleSigningType || p.Critical.Image.DockerManifestDigest != desc.Digest.String() {
+		return false
+	}
+	// a signature of the same manifest in another repository does not vouch for this one
+	if p.Critical.Identity.DockerReference != identity {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return verifyPayload(key, payload, sig, false)
+}
+
+// verifyJWS verifies a JWS envelope signs desc with key.
+func verifyJWS(b []byte, desc ocispec.Descriptor, key crypto.PublicKey) bool {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return false
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return false
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return false
+	}
+	if header.ContentType != JWSPayloadContentType {
+		return false
+	}
+	if alg, err := jwsAlgorithm(key); err != nil || alg != header.Algorithm {
+		return false
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return false
+	}
+	var payload jwsPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return false
+	}
+	if payload.TargetArtifact.Digest != desc.Digest || payload.TargetArtifact.Size != desc.Size {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return false
+	}
+	return verifyPayload(key, []byte(envelope.Protected+"."+envelope.Payload), sig, true)
+}
+
+// verifyPayload verifies a signature made by signPayload.
+func verifyPayload(key crypto.PublicKey, b, sig []byte, raw bool) bool {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		h := ecdsaHash(key.Curve).New()
+		h.Write(b)
+		if !raw {
+			return ecdsa.VerifyASN1(key, h.Sum(nil), sig)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:size]),
+			S: new(big.Int).SetBytes(sig[size:]),
+		})
+		if err != nil {
+			return false
+		}
+		return ecdsa.VerifyASN1(key, h.Sum(nil), der)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, b, sig)
+	default:
+		return false
+	}
+}
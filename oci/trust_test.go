@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2"
+	ocistore "oras.land/oras-go/v2/content/oci"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func TestLoadVerificationKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pub")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), helpers.ReadWriteUser))
+	key, err := LoadVerificationKey(path)
+	require.NoError(t, err)
+	require.True(t, edKey.Public().(ed25519.PublicKey).Equal(key))
+
+	_, err = LoadVerificationKey(writeSigningKey(t, edKey))
+	require.ErrorContains(t, err, "no PEM encoded public key")
+}
+
+func (suite *OCISuite) TestTrustPolicy() {
+	ctx := context.TODO()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.NoError(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	suite.NoError(err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	suite.NoError(err)
+
+	ref := suite.remote.Repo().Reference
+	publish := func(name string, modifiers ...Modifier) {
+		// the prefix keeps the manifests distinct from those signed by other tests
+		remote, desc := suite.pushTestManifest("oci://"+ref.String(), PlatformForArch(testArch), "trusted "+name, modifiers...)
+		suite.NoError(remote.UpdateIndex(ctx, "0.0.1", desc))
+	}
+	fetch := func(policy TrustPolicy) error {
+		remote, err := NewOrasRemote("oci://"+ref.String(), PlatformForArch(testArch), WithPlainHTTP(true), WithTrustPolicy(policy))
+		suite.NoError(err)
+		_, err = remote.FetchRoot(ctx)
+		return err
+	}
+	rule := func(pattern string, keys ...crypto.PublicKey) TrustRule {
+		return TrustRule{Pattern: pattern, Keys: keys}
+	}
+	repo := ref.Registry + "/" + ref.Repository
+
+	publish("unsigned")
+	var unsigned *UnsignedError
+	suite.ErrorAs(fetch(TrustPolicy{Rules: []TrustRule{rule(repo, edKey.Public())}}), &unsigned)
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule(repo, edKey.Public())}, AuditOnly: true}))
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule("example.com/*", edKey.Public())}}))
+
+	publish("simple signing", WithSigner(Signer{Key: ecKey}))
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule(ref.Registry+"/*", otherKey.Public(), ecKey.Public())}}))
+	var untrusted *UntrustedSignatureError
+	err = fetch(TrustPolicy{Rules: []TrustRule{rule(repo, otherKey.Public())}})
+	suite.ErrorAs(err, &untrusted)
+	suite.ErrorIs(err, ErrUntrusted)
+	suite.Equal(1, untrusted.Signatures)
+	// the most specific rule wins
+	suite.ErrorIs(fetch(TrustPolicy{Rules: []TrustRule{rule(ref.Registry+"/*", ecKey.Public()), rule(repo, otherKey.Public())}}), ErrUntrusted)
+
+	publish("jws tag", WithSigner(Signer{Key: edKey, Format: SignatureFormatJWS, Storage: SignatureStorageTag}))
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule(repo, edKey.Public())}}))
+	suite.ErrorIs(fetch(TrustPolicy{Rules: []TrustRule{rule(repo, ecKey.Public())}}), ErrUntrusted)
+
+	// repositories that match no rule are refused when unmatched repositories are denied
+	suite.ErrorIs(fetch(TrustPolicy{Rules: []TrustRule{rule("example.com/*", edKey.Public())}, DenyUnmatched: true}), ErrUntrusted)
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule("example.com/*", edKey.Public())}, DenyUnmatched: true, AuditOnly: true}))
+
+	// signatures that can't be read are skipped
+	root, err := suite.remote.ResolveRoot(ctx)
+	suite.NoError(err)
+	broken, err := suite.remote.PushLayer(ctx, []byte("broken"), SimpleSigningMediaType)
+	suite.NoError(err)
+	_, err = oras.PackManifest(ctx, suite.remote.Repo(), oras.PackManifestVersion1_1, SimpleSigningArtifactType,
+		oras.PackManifestOptions{Subject: &root, Layers: []ocispec.Descriptor{*broken}})
+	suite.NoError(err)
+	suite.NoError(suite.remote.Repo().Blobs().Delete(ctx, *broken))
+	suite.NoError(fetch(TrustPolicy{Rules: []TrustRule{rule(repo, edKey.Public())}}))
+	err = fetch(TrustPolicy{Rules: []TrustRule{rule(repo, ecKey.Public())}})
+	suite.ErrorAs(err, &untrusted)
+	suite.Equal(2, untrusted.Signatures)
+
+	// a simple signing signature made for another repository is not trusted here
+	other, err := NewOrasRemote("oci://"+ref.Registry+"/other:"+ref.Reference, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	layer, b, err := other.simpleSigningLayer(root, ecKey)
+	suite.NoError(err)
+	copied, err := suite.remote.PushLayer(ctx, b, SimpleSigningMediaType)
+	suite.NoError(err)
+	copied.Annotations = layer.Annotations
+	_, err = oras.PackManifest(ctx, suite.remote.Repo(), oras.PackManifestVersion1_1, SimpleSigningArtifactType,
+		oras.PackManifestOptions{Subject: &root, Layers: []ocispec.Descriptor{*copied}})
+	suite.NoError(err)
+	err = fetch(TrustPolicy{Rules: []TrustRule{rule(repo, ecKey.Public())}})
+	suite.ErrorAs(err, &untrusted)
+	suite.Equal(3, untrusted.Signatures)
+
+	// the verified signature is read through the cache and recorded for offline use
+	store, err := ocistore.New(suite.T().TempDir())
+	suite.NoError(err)
+	policy := TrustPolicy{Rules: []TrustRule{rule(repo, edKey.Public())}}
+	prefer, err := NewOrasRemote("oci://"+ref.String(), PlatformForArch(testArch), WithPlainHTTP(true), WithCache(store), WithPreferCache(), WithTrustPolicy(policy))
+	suite.NoError(err)
+	_, err = prefer.FetchRoot(ctx)
+	suite.NoError(err)
+	offline, err := NewOrasRemote("oci://"+ref.String(), PlatformForArch(testArch), WithCache(store), WithOffline(), WithTrustPolicy(policy))
+	suite.NoError(err)
+	_, err = offline.FetchRoot(ctx)
+	suite.NoError(err)
+	offline, err = NewOrasRemote("oci://"+ref.String(), PlatformForArch(testArch), WithCache(store), WithOffline(),
+		WithTrustPolicy(TrustPolicy{Rules: []TrustRule{rule(repo, ecKey.Public())}}))
+	suite.NoError(err)
+	_, err = offline.FetchRoot(ctx)
+	suite.ErrorIs(err, ErrUntrusted)
+
+	// Copy refuses untrusted sources before copying any layers
+	src, err := NewOrasRemote("oci://"+ref.String(), PlatformForArch(testArch), WithPlainHTTP(true),
+		WithTrustPolicy(TrustPolicy{Rules: []TrustRule{rule(repo, otherKey.Public())}}))
+	suite.NoError(err)
+	dst, err := NewOrasRemote(suite.setupInMemoryRegistry(ctx), PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	suite.ErrorIs(Copy(ctx, src, dst, nil, 1, nil), ErrUntrusted)
+}
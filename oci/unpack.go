@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+const (
+	// AnnotationUnpack marks a tar layer holding a directory that is unpacked when pulled, as used by ORAS
+	AnnotationUnpack = "io.deis.oras.content.unpack"
+	// annotationContentDigest is the digest of the uncompressed tar of a directory layer pushed by ORAS
+	annotationContentDigest = "io.deis.oras.content.digest"
+)
+
+// PushDirectoryLayer pushes dir as a single reproducible tar layer titled title, compressed with compression, and
+// returns its descriptor.
+//
+// Entries in the tar are prefixed with title and normalized like helpers.CreateReproducibleTarballFromDir, so pushing
+// the same directory twice results in the same layer. The layer is annotated with AnnotationUnpack, PullPath unpacks
+// it to `destinationDir/title`.
+func (o *OrasRemote) PushDirectoryLayer(ctx context.Context, dir string, title string, compression Compression) (*ocispec.Descriptor, error) {
+	if err := o.validateTitle(title); err != nil {
+		return nil, err
+	}
+	ctx = withoutMirrors(ctx)
+	tmpDir, err := os.MkdirTemp("", "oci-push-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tarPath := filepath.Join(tmpDir, "layer.tar")
+	layer, layerPath, err := describeDirectory(dir, title, tarPath, compression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	if err := o.pushFile(ctx, layerPath, layer); err != nil {
+		return nil, err
+	}
+	return &layer, nil
+}
+
+// describeDirectory writes dir as a reproducible tarball to tarPath, and returns its layer descriptor once compressed
+// with compression along with the path of the layer content, the tarball compressed next to it.
+func describeDirectory(dir string, title string, tarPath string, compression Compression) (ocispec.Descriptor, string, error) {
+	if err := helpers.CreateReproducibleTarballFromDir(dir, title, tarPath); err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	layerPath := tarPath
+	if compression != CompressionNone {
+		layerPath = tarPath + "." + string(compression)
+	}
+	layer, err := describeFile(f, info.Size(), ocispec.MediaTypeImageLayer, compression, layerPath)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	tarDigest := layer.Digest
+	if compression != CompressionNone {
+		tarDigest = digest.Digest(layer.Annotations[AnnotationUncompressedDigest])
+	}
+	layer.Annotations[ocispec.AnnotationTitle] = title
+	layer.Annotations[AnnotationUnpack] = "true"
+	layer.Annotations[annotationContentDigest] = tarDigest.String()
+	return layer, layerPath, nil
+}
+
+// isDirectoryLayer returns true if the layer holds a directory to unpack.
+func isDirectoryLayer(desc ocispec.Descriptor) bool {
+	return desc.Annotations[AnnotationUnpack] == "true"
+}
+
+// tarDigest returns the digest of the uncompressed tar of a directory layer, or an empty digest if it is unknown.
+func tarDigest(desc ocispec.Descriptor) digest.Digest {
+	if _, uncompressed := layerCompression(desc); uncompressed != "" {
+		return uncompressed
+	}
+	if dgst := desc.Annotations[annotationContentDigest]; dgst != "" {
+		return digest.Digest(dgst)
+	}
+	if mediaTypeCompression(desc.MediaType) == CompressionNone {
+		return desc.Digest
+	}
+	return ""
+}
+
+// pullDirectory downloads the directory layer desc and unpacks it to fullPath, replacing anything already there.
+//
+// The layer is unpacked to `fullPath.partial` and renamed into place once every entry has been written.
+func (o *OrasRemote) pullDirectory(ctx context.Context, fullPath string, desc ocispec.Descriptor) error {
+	title := desc.Annotations[ocispec.AnnotationTitle]
+	tarPath := fullPath + partialSuffix + ".tar"
+	if info, err := os.Lstat(tarPath); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(tarPath); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(tarPath, os.O_RDWR|os.O_CREATE, helpers.ReadWriteUser)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := o.downloadLayer(ctx, file, desc); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// layers compressed without AnnotationUncompressedDigest, such as those pushed by ORAS, are still compressed
+	var r io.Reader = file
+	if compression, _ := layerCompression(desc); compression == CompressionNone {
+		if compression := mediaTypeCompression(desc.MediaType); compression != CompressionNone {
+			dr, err := decompressReader(file, compression)
+			if err != nil {
+				return err
+			}
+			defer dr.Close()
+			r = dr
+		}
+	}
+	expected := tarDigest(desc)
+	digester := digest.Canonical.Digester()
+	if expected != "" {
+		if err := expected.Validate(); err != nil {
+			return fmt.Errorf("%s: invalid tar digest: %w", desc.Digest, err)
+		}
+		digester = expected.Algorithm().Digester()
+	}
+	r = io.TeeReader(r, digester.Hash())
+
+	stagingPath := fullPath + partialSuffix
+	if err := removeDirectory(stagingPath); err != nil {
+		return err
+	}
+	err = o.untar(r, stagingPath, title)
+	if err == nil {
+		// read the tar padding so it is part of the digest
+		_, err = io.Copy(io.Discard, r)
+	}
+	if err == nil && expected != "" && digester.Digest() != expected {
+		err = fmt.Errorf("%s: tar %s: %w", desc.Digest, expected, content.ErrMismatchedDigest)
+	}
+	if err != nil {
+		return errors.Join(err, removeDirectory(stagingPath), file.Close(), os.Remove(tarPath))
+	}
+
+	if err := removeDirectory(fullPath); err != nil {
+		return err
+	}
+	if err := os.Rename(stagingPath, fullPath); err != nil {
+		return err
+	}
+	return errors.Join(file.Close(), os.Remove(tarPath))
+}
+
+// removeDirectory removes the unpacked directory at path like os.RemoveAll, first giving the owner full access to every
+// directory within it, as read-only directories unpacked from a layer can't be emptied otherwise.
+func removeDirectory(path string) error {
+	// a failure to walk the tree is reported by the removal
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil && info.Mode().Perm()&0o700 != 0o700 {
+			_ = os.Chmod(p, info.Mode().Perm()|0o700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
+
+// untar unpacks the entries of the tar read from r below title to dir.
+//
+// Every entry must be title or within it, symlinks must point within title, and only directories, regular files and
+// symlinks are allowed. In strict mode (see WithStrictPaths) symlinks are refused.
+func (o *OrasRemote) untar(r io.Reader, dir string, title string) error {
+	if err := os.MkdirAll(dir, helpers.ReadExecuteAllWriteUser); err != nil {
+		return err
+	}
+
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(hdr.Name)
+		rel, ok := strings.CutPrefix(name, title+"/")
+		if name == title {
+			rel, ok = ".", true
+		}
+		if !ok || !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return &UnsafePathError{Title: title, Reason: fmt.Sprintf("entry %q is outside of the layer directory", hdr.Name)}
+		}
+		rel = filepath.FromSlash(rel)
+		target := filepath.Join(dir, rel)
+		if rel != "." {
+			if err := o.checkSymlinks(dir, rel); err != nil {
+				return &UnsafePathError{Title: title, Reason: err.Error()}
+			}
+			if err := os.MkdirAll(filepath.Dir(target), helpers.ReadExecuteAllWriteUser); err != nil {
+				return err
+			}
+		}
+
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, helpers.ReadExecuteAllWriteUser); err != nil {
+				return err
+			}
+			// directories are made read-only last so their entries can still be written
+			dirs = append(dirs, dirMode{target, mode})
+		case tar.TypeReg:
+			if err := writeTarFile(tr, target, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if o.strictPaths {
+				return &UnsafePathError{Title: title, Reason: fmt.Sprintf("entry %q is a symlink", hdr.Name)}
+			}
+			resolved := path.Join(path.Dir(filepath.ToSlash(rel)), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.FromSlash(resolved)) {
+				return &UnsafePathError{Title: title, Reason: fmt.Sprintf("symlink %q points outside of the layer directory", hdr.Name)}
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			return &UnsafePathError{Title: title, Reason: fmt.Sprintf("entry %q has unsupported type %q", hdr.Name, hdr.Typeflag)}
+		}
+	}
+
+	for _, d := range slices.Backward(dirs) {
+		if err := os.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTarFile writes the current entry of tr to a new file at path with the given mode.
+func writeTarFile(tr *tar.Reader, path string, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, helpers.ReadWriteUser)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, tr); err != nil {
+		return errors.Join(err, f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// the mode is set explicitly so it is not affected by the umask
+	return os.Chmod(path, mode)
+}
+
+// directoryMatches returns true if the directory at dirPath archives to the tar of the directory layer desc.
+func directoryMatches(dirPath string, desc ocispec.Descriptor) bool {
+	expected := tarDigest(desc)
+	if expected.Validate() != nil {
+		return false
+	}
+	info, err := os.Lstat(dirPath)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	tmpDir, err := os.MkdirTemp("", "oci-verify-*")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(tmpDir)
+	tarPath := filepath.Join(tmpDir, "layer.tar")
+	if err := helpers.CreateReproducibleTarballFromDir(dirPath, desc.Annotations[ocispec.AnnotationTitle], tarPath); err != nil {
+		return false
+	}
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	actual, err := expected.Algorithm().FromReader(f)
+	if err != nil {
+		return false
+	}
+	return actual == expected
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/defenseunicorns/pkg/helpers/v2"
+)
+
+func (suite *OCISuite) TestDirectoryLayer() {
+	ctx := context.TODO()
+	url := strings.Replace(suite.setupInMemoryRegistry(ctx), ":1.0.1", ":0.0.1", 1)
+
+	srcDir := suite.T().TempDir()
+	suite.NoError(os.WriteFile(filepath.Join(srcDir, "zarf.yaml"), []byte("kind: ZarfPackageConfig"), helpers.ReadWriteUser))
+	componentsDir := filepath.Join(srcDir, "components")
+	suite.NoError(helpers.CreateDirectory(filepath.Join(componentsDir, "nested"), helpers.ReadExecuteAllWriteUser))
+	suite.NoError(os.WriteFile(filepath.Join(componentsDir, "first.tar"), []byte("first component"), helpers.ReadWriteUser))
+	suite.NoError(os.WriteFile(filepath.Join(componentsDir, "nested", "run.sh"), []byte("#!/bin/sh"), helpers.ReadWriteExecuteUser))
+	suite.NoError(os.Symlink("first.tar", filepath.Join(componentsDir, "link.tar")))
+
+	remote, err := NewOrasRemote(url, PlatformForArch(testArch), WithPlainHTTP(true))
+	suite.NoError(err)
+	desc, err := remote.PushFiles(ctx, "0.0.1", srcDir, []string{"zarf.yaml", "components"}, WithPushCompression(CompressionGzip))
+	suite.NoError(err)
+	manifest, err := remote.FetchManifest(ctx, desc)
+	suite.NoError(err)
+	layer := manifest.Locate("components")
+	suite.Equal(ocispec.MediaTypeImageLayerGzip, layer.MediaType)
+	suite.Equal("true", layer.Annotations[AnnotationUnpack])
+	suite.Equal(layer.Annotations[AnnotationUncompressedDigest], layer.Annotations[annotationContentDigest])
+
+	// the directory is archived reproducibly
+	pushed, err := remote.PushDirectoryLayer(ctx, componentsDir, "components", CompressionGzip)
+	suite.NoError(err)
+	suite.Equal(layer.Digest, pushed.Digest)
+
+	dstDir := suite.T().TempDir()
+	_, err = remote.PullPaths(ctx, dstDir, []string{"zarf.yaml", "components"})
+	suite.NoError(err)
+	b, err := os.ReadFile(filepath.Join(dstDir, "components", "link.tar"))
+	suite.NoError(err)
+	suite.Equal("first component", string(b))
+	info, err := os.Stat(filepath.Join(dstDir, "components", "nested", "run.sh"))
+	suite.NoError(err)
+	suite.Equal(fs.FileMode(helpers.ReadWriteExecuteUser), info.Mode().Perm())
+	suite.NoFileExists(filepath.Join(dstDir, "components.partial.tar"))
+	suite.True(remote.FileDescriptorExists(layer, dstDir))
+
+	// pulling again replaces the directory, and a modified directory no longer matches
+	suite.NoError(os.WriteFile(filepath.Join(dstDir, "components", "extra"), []byte("extra"), helpers.ReadWriteUser))
+	suite.False(remote.FileDescriptorExists(layer, dstDir))
+	suite.NoError(remote.PullPath(ctx, dstDir, layer))
+	suite.NoFileExists(filepath.Join(dstDir, "components", "extra"))
+	suite.True(remote.FileDescriptorExists(layer, dstDir))
+
+	// read-only directories keep their mode and are replaced by the next pull
+	readOnlyDir := suite.T().TempDir()
+	suite.T().Cleanup(func() { _ = removeDirectory(readOnlyDir) })
+	suite.T().Cleanup(func() { _ = removeDirectory(filepath.Join(dstDir, "readonly")) })
+	suite.NoError(helpers.CreateDirectory(filepath.Join(readOnlyDir, "locked"), helpers.ReadExecuteAllWriteUser))
+	suite.NoError(os.WriteFile(filepath.Join(readOnlyDir, "locked", "file"), []byte("locked"), helpers.ReadWriteUser))
+	suite.NoError(os.Chmod(filepath.Join(readOnlyDir, "locked"), 0o555))
+	readOnly, err := remote.PushDirectoryLayer(ctx, readOnlyDir, "readonly", CompressionNone)
+	suite.NoError(err)
+	suite.NoError(remote.PullPath(ctx, dstDir, *readOnly))
+	info, err = os.Stat(filepath.Join(dstDir, "readonly", "locked"))
+	suite.NoError(err)
+	suite.Equal(fs.FileMode(0o555), info.Mode().Perm())
+	suite.True(remote.FileDescriptorExists(*readOnly, dstDir))
+	suite.NoError(remote.PullPath(ctx, dstDir, *readOnly))
+	suite.True(remote.FileDescriptorExists(*readOnly, dstDir))
+
+	// layers with entries outside of the directory are refused
+	for name, hdr := range map[string]*tar.Header{
+		"outside": {Name: "other/file", Typeflag: tar.TypeReg, Mode: 0o644},
+		"escape":  {Name: "escape/../../file", Typeflag: tar.TypeReg, Mode: 0o644},
+		"symlink": {Name: "symlink/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd", Mode: 0o777},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		suite.NoError(tw.WriteHeader(hdr))
+		suite.NoError(tw.Close())
+		malicious, err := remote.PushLayer(ctx, buf.Bytes(), ocispec.MediaTypeImageLayer)
+		suite.NoError(err)
+		malicious.Annotations = map[string]string{ocispec.AnnotationTitle: name, AnnotationUnpack: "true"}
+
+		var unsafe *UnsafePathError
+		err = remote.PullPath(ctx, dstDir, *malicious)
+		suite.ErrorAs(err, &unsafe, name)
+		suite.NoDirExists(filepath.Join(dstDir, name))
+		suite.NoDirExists(filepath.Join(dstDir, name+partialSuffix))
+	}
+}
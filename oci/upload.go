@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	// defaultUploadChunkSize is the default size of each PATCH request of a streamed layer upload
+	defaultUploadChunkSize = 8 << 20
+	// uploadChunkAttempts is the number of times a chunk is sent before the upload fails
+	uploadChunkAttempts = 3
+)
+
+// WithUploadChunkSize sets the size of each chunk sent by PushLayerStream, 8MiB by default
+func WithUploadChunkSize(size int) Modifier {
+	return func(o *OrasRemote) {
+		if size > 0 {
+			o.uploadChunkSize = size
+		}
+	}
+}
+
+// PushLayerStream pushes the layer read from r to the remote repository without holding it in memory, and returns
+// its descriptor.
+//
+// Pass a negative size if the size is unknown, and an empty digest if it is not known ahead of time. When both are
+// known and the registry already has the layer, r is not read. The layer is sent in chunks (see WithUploadChunkSize),
+// a chunk that fails is resumed from the last byte the registry received. Progress is written to the progress writer
+// set with SetProgressWriter.
+func (o *OrasRemote) PushLayerStream(ctx context.Context, r io.Reader, size int64, dgst digest.Digest, mediaType string) (*ocispec.Descriptor, error) {
+	ctx = withoutMirrors(ctx)
+	algorithm := digest.Canonical
+	if dgst != "" {
+		if err := dgst.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid digest %q: %w", dgst, err)
+		}
+		algorithm = dgst.Algorithm()
+		if size >= 0 {
+			desc := ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: size}
+			exists, err := o.blobExists(ctx, desc)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				if bar := o.progTransport.ProgressBar; bar != nil {
+					writeSkippedProgress(bar, desc.Size)
+				}
+				return &desc, o.printLayerSkipped(ctx, desc)
+			}
+		}
+	}
+
+	ctx = auth.AppendRepositoryScope(ctx, o.repo.Reference, auth.ActionPull, auth.ActionPush)
+	location, err := o.startUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := o.streamUpload(ctx, location, r, size, dgst, algorithm.Digester())
+	if err != nil {
+		// the session is abandoned, let the registry clean it up right away
+		if req, reqErr := http.NewRequestWithContext(ctx, http.MethodDelete, location.String(), nil); reqErr == nil {
+			if resp, doErr := o.uploadClient().Do(req); doErr == nil {
+				resp.Body.Close()
+			}
+		}
+		return nil, err
+	}
+	desc.MediaType = mediaType
+	return &desc, o.printLayerCopied(ctx, desc)
+}
+
+// streamUpload sends everything read from r to the upload session at location, then commits it.
+func (o *OrasRemote) streamUpload(ctx context.Context, location *url.URL, r io.Reader, size int64, dgst digest.Digest, digester digest.Digester) (ocispec.Descriptor, error) {
+	chunk := make([]byte, o.uploadChunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, chunk)
+		if n > 0 {
+			digester.Hash().Write(chunk[:n])
+			var err error
+			location, err = o.uploadChunk(ctx, location, chunk[:n], offset)
+			if err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			offset += int64(n)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return ocispec.Descriptor{}, readErr
+		}
+	}
+
+	if size >= 0 && offset != size {
+		return ocispec.Descriptor{}, fmt.Errorf("layer size mismatch: expected %d bytes, read %d", size, offset)
+	}
+	actual := digester.Digest()
+	if dgst != "" && actual != dgst {
+		return ocispec.Descriptor{}, fmt.Errorf("layer digest mismatch: expected %s, got %s", dgst, actual)
+	}
+
+	// commit the upload
+	query := location.Query()
+	query.Set("digest", actual.String())
+	location.RawQuery = query.Encode()
+	resp, err := o.doUploadRequest(ctx, http.MethodPut, location, nil, nil, http.StatusCreated)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	resp.Body.Close()
+	return ocispec.Descriptor{Digest: actual, Size: offset}, nil
+}
+
+// startUpload opens an upload session and returns its location.
+func (o *OrasRemote) startUpload(ctx context.Context) (*url.URL, error) {
+	scheme := "https"
+	if o.repo.PlainHTTP {
+		scheme = "http"
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   o.repo.Reference.Host(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/uploads/", o.repo.Reference.Repository),
+	}
+	resp, err := o.doUploadRequest(ctx, http.MethodPost, u, nil, nil, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return resp.Location()
+}
+
+// uploadChunk sends chunk, which starts at offset in the layer, to the upload session at location and returns the
+// location to continue the upload at.
+//
+// Failed requests are resumed from the last byte the registry received, up to uploadChunkAttempts times.
+func (o *OrasRemote) uploadChunk(ctx context.Context, location *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	patch := func(sent int64) (*http.Response, error) {
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("%d-%d", offset+sent, offset+int64(len(chunk))-1)},
+		}
+		return o.doUploadRequest(ctx, http.MethodPatch, location, header, chunk[sent:], http.StatusAccepted)
+	}
+
+	var err error
+	for attempt := 1; attempt <= uploadChunkAttempts; attempt++ {
+		var sent int64
+		var ambiguous bool
+		if attempt > 1 {
+			var received int64
+			var statusErr error
+			location, received, ambiguous, statusErr = o.uploadStatus(ctx, location)
+			if statusErr != nil {
+				return nil, errors.Join(err, statusErr)
+			}
+			sent = received - offset
+			if sent < 0 || sent > int64(len(chunk)) {
+				return nil, fmt.Errorf("unable to resume upload: registry has %d bytes, chunk covers %d-%d: %w",
+					received, offset, offset+int64(len(chunk)), err)
+			}
+			if sent == int64(len(chunk)) {
+				return location, nil
+			}
+			if o.log != nil {
+				o.log.Debug("resuming layer upload", "offset", received, "attempt", attempt, "error", err.Error())
+			}
+		}
+
+		var resp *http.Response
+		resp, err = patch(sent)
+		var statusErr *uploadStatusError
+		if ambiguous && sent == 0 && errors.As(err, &statusErr) && statusErr.code == http.StatusRequestedRangeNotSatisfiable {
+			// the registry has the first byte, not an empty upload
+			if len(chunk) == 1 {
+				return location, nil
+			}
+			resp, err = patch(1)
+		}
+		if err == nil {
+			defer resp.Body.Close()
+			return resp.Location()
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// uploadStatus returns the location to continue the upload session at, and the number of bytes the registry received.
+//
+// Registries report both an empty upload and an upload of a single byte as the range 0-0, in which case 0 is returned
+// and ambiguous is true.
+func (o *OrasRemote) uploadStatus(ctx context.Context, location *url.URL) (next *url.URL, received int64, ambiguous bool, err error) {
+	resp, err := o.doUploadRequest(ctx, http.MethodGet, location, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer resp.Body.Close()
+	next, err = resp.Location()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	// the Range header holds the inclusive range of bytes received so far, e.g. 0-1023
+	rng := resp.Header.Get("Range")
+	if rng == "" {
+		return next, 0, false, nil
+	}
+	_, end, ok := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+	if !ok {
+		return nil, 0, false, fmt.Errorf("invalid upload range %q", rng)
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("invalid upload range %q: %w", rng, err)
+	}
+	if last == 0 {
+		return next, 0, true, nil
+	}
+	return next, last + 1, false, nil
+}
+
+// uploadStatusError is returned by doUploadRequest when the registry responds with an unexpected status.
+type uploadStatusError struct {
+	method string
+	url    string
+	status string
+	code   int
+	body   string
+}
+
+// Error returns the error message.
+func (e *uploadStatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %s: %s", e.method, e.url, e.status, e.body)
+}
+
+// doUploadRequest sends a request for an upload session and checks it returned the expected status.
+func (o *OrasRemote) doUploadRequest(ctx context.Context, method string, u *url.URL, header http.Header, body []byte, expected int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := o.uploadClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &uploadStatusError{
+			method: method,
+			url:    u.Redacted(),
+			status: resp.Status,
+			code:   resp.StatusCode,
+			body:   strings.TrimSpace(string(b)),
+		}
+	}
+	return resp, nil
+}
+
+// uploadClient returns the client of the registry for upload sessions, which are never sent to mirrors.
+func (o *OrasRemote) uploadClient() remote.Client {
+	if client, ok := o.authClient(); ok {
+		return client
+	}
+	return o.repo.Client
+}
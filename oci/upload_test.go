@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2024-Present Defense Unicorns
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// flakyUploadTransport fails the PATCH requests whose number is in failBefore without sending them,
+// and those in failAfter once the registry has received them.
+type flakyUploadTransport struct {
+	http.RoundTripper
+	failBefore map[int]bool
+	failAfter  map[int]bool
+	patches    int
+}
+
+func (t *flakyUploadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	t.patches++
+	if t.failBefore[t.patches] {
+		return nil, errors.New("connection reset before sending")
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && t.failAfter[t.patches] {
+		resp.Body.Close()
+		return nil, errors.New("connection reset after sending")
+	}
+	return resp, err
+}
+
+func (suite *OCISuite) TestPushLayerStream() {
+	ctx := context.TODO()
+	layer := make([]byte, 10*1024+100)
+	_, err := rand.Read(layer)
+	suite.NoError(err)
+	expected := digest.FromBytes(layer)
+
+	tests := []struct {
+		name       string
+		size       int64
+		dgst       digest.Digest
+		failBefore map[int]bool
+		failAfter  map[int]bool
+		patches    int
+	}{
+		{name: "unknown size and digest", size: -1, patches: 11},
+		{name: "known size and digest", size: int64(len(layer)), dgst: expected, patches: 11},
+		// a chunk the registry never received is sent again, one it received is not
+		{name: "resumed", size: -1, failBefore: map[int]bool{1: true, 3: true}, failAfter: map[int]bool{6: true}, patches: 13},
+	}
+	for _, tt := range tests {
+		remote, err := NewOrasRemote(suite.setupInMemoryRegistry(ctx), PlatformForArch(testArch), WithPlainHTTP(true), WithUploadChunkSize(1024))
+		suite.NoError(err)
+		transport := &flakyUploadTransport{RoundTripper: remote.progTransport.Base, failBefore: tt.failBefore, failAfter: tt.failAfter}
+		remote.progTransport.Base = transport
+		progress := &TestProgressWriter{}
+		remote.SetProgressWriter(progress)
+
+		desc, err := remote.PushLayerStream(ctx, bytes.NewReader(layer), tt.size, tt.dgst, ocispec.MediaTypeImageLayer)
+		suite.NoError(err, tt.name)
+		suite.Equal(expected, desc.Digest)
+		suite.Equal(int64(len(layer)), desc.Size)
+		suite.Equal(ocispec.MediaTypeImageLayer, desc.MediaType)
+		suite.Equal(tt.patches, transport.patches, tt.name)
+		suite.GreaterOrEqual(progress.bytesSent, len(layer), tt.name)
+
+		b, err := remote.FetchLayer(ctx, *desc)
+		suite.NoError(err)
+		suite.Equal(layer, b)
+
+		// an existing layer is not read again, and is reported to the progress writer once
+		skipped := &TestProgressWriter{}
+		remote.SetProgressWriter(skipped)
+		_, err = remote.PushLayerStream(ctx, errReader{}, desc.Size, desc.Digest, ocispec.MediaTypeImageLayer)
+		suite.NoError(err)
+		suite.Equal(len(layer), skipped.bytesSent, tt.name)
+	}
+
+	// a registry that received a single byte reports the same range as an empty upload
+	remote, err := NewOrasRemote(suite.setupInMemoryRegistry(ctx), PlatformForArch(testArch), WithPlainHTTP(true), WithUploadChunkSize(1))
+	suite.NoError(err)
+	transport := &flakyUploadTransport{RoundTripper: remote.progTransport.Base, failAfter: map[int]bool{1: true}}
+	remote.progTransport.Base = transport
+	desc, err := remote.PushLayerStream(ctx, bytes.NewReader([]byte("abc")), -1, "", ocispec.MediaTypeImageLayer)
+	suite.NoError(err)
+	b, err := remote.FetchLayer(ctx, *desc)
+	suite.NoError(err)
+	suite.Equal("abc", string(b))
+
+	remote, err = NewOrasRemote(suite.setupInMemoryRegistry(ctx), PlatformForArch(testArch), WithPlainHTTP(true), WithUploadChunkSize(1024))
+	suite.NoError(err)
+	_, err = remote.PushLayerStream(ctx, bytes.NewReader(layer), -1, digest.FromString("something else"), ocispec.MediaTypeImageLayer)
+	suite.ErrorContains(err, "layer digest mismatch")
+	_, err = remote.PushLayerStream(ctx, bytes.NewReader(layer), 10, "", ocispec.MediaTypeImageLayer)
+	suite.ErrorContains(err, "layer size mismatch")
+	_, err = remote.PushLayerStream(ctx, io.MultiReader(bytes.NewReader(layer), errReader{}), -1, "", ocispec.MediaTypeImageLayer)
+	suite.ErrorContains(err, "read failed")
+}
+
+// errReader fails every read.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}